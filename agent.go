@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// Agent checks let servers report their own state and weight to HAProxy. A
+// broken agent is a different failure than a failing health check: the server
+// may be healthy, but HAProxy no longer learns when it should drain it. Agent
+// checks are therefore judged separately from the health checks.
+
+// agentPassed reports whether the last agent check of a server passed. ok is
+// false if the server has no agent check, or its result is not known yet.
+func agentPassed(row statRow) (passed, ok bool) {
+	status := strings.TrimPrefix(row.String("agent_status"), "* ")
+	switch status {
+	case "", "UNK", "INI":
+		return false, false
+	case "L4OK", "L6OK", "L7OK", "L7OKC", "PROCOK":
+		return true, true
+	default:
+		return false, true
+	}
+}
+
+// agentRule warns about servers whose agent check fails, or is critical about
+// them if config.AgentCritical is set.
+func agentRule(e *evaluation) []result {
+	status := sensu.CheckStateWarning
+	if config.AgentCritical {
+		status = sensu.CheckStateCritical
+	}
+	var results []result
+	for _, row := range e.rows {
+		if row.Type() != "server" {
+			continue
+		}
+		if passed, ok := agentPassed(row); !ok || passed {
+			continue
+		}
+		output := fmt.Sprintf("server %s/%s agent check is failing: %s", row.Proxy(), row.Server(), checkDetail(row, "agent"))
+		health, ok1 := row.Float("agent_health")
+		rise, ok2 := row.Float("agent_rise")
+		fall, ok3 := row.Float("agent_fall")
+		if ok1 && ok2 && ok3 {
+			output += fmt.Sprintf(", health %.0f/%.0f (rise %.0f, fall %.0f)", health, rise+fall-1, rise, fall)
+		}
+		results = append(results, newResult(row, status, "%s", output))
+	}
+	return results
+}
+
+// exportAgentMetrics exports whether the last agent check of every server
+// with an agent check passed.
+func exportAgentMetrics(e *exporter, rows []statRow, previous *snapshot) {
+	gauge := e.gauge("haproxy_agent_check_passed", "agent check passed", tags...)
+	for _, row := range rows {
+		passed, ok := agentPassed(row)
+		if !ok {
+			continue
+		}
+		var value float64
+		if passed {
+			value = 1
+		}
+		gauge.WithLabelValues(rowLabels(row)...).Set(value)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func agentCSV(t *testing.T) []byte {
+	csv := setColumns(t, testDataCSV, "app", "app1", map[string]string{
+		"agent_status":   "L7OK",
+		"agent_duration": "1",
+		"agent_rise":     "2",
+		"agent_fall":     "3",
+		"agent_health":   "4",
+	})
+	return setColumns(t, csv, "app", "app2", map[string]string{
+		"agent_status":   "L4CON",
+		"agent_duration": "0",
+		"agent_desc":     "Layer4 connection problem",
+		"last_agt":       "Connection refused",
+		"agent_rise":     "2",
+		"agent_fall":     "3",
+		"agent_health":   "0",
+	})
+}
+
+func TestAgentRule(t *testing.T) {
+	withConfig(defaultConfig(), func() {
+		results := agentRule(&evaluation{rows: testRows(t, agentCSV(t))})
+		if got, want := len(results), 1; got != want {
+			t.Fatalf("bad result count: got %d, want %d", got, want)
+		}
+		want := "server app/app2 agent check is failing: agent L4CON (layer 1-4 connection problem) in 0ms: Layer4 connection problem, Connection refused, health 0/4 (rise 2, fall 3)"
+		if got := results[0].Output; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+		if got, want := results[0].Status, sensu.CheckStateWarning; got != want {
+			t.Errorf("bad status: got %d, want %d", got, want)
+		}
+	})
+	cfg := defaultConfig()
+	cfg.AgentCritical = true
+	withConfig(cfg, func() {
+		results := agentRule(&evaluation{rows: testRows(t, agentCSV(t))})
+		if len(results) != 1 || results[0].Status != sensu.CheckStateCritical {
+			t.Errorf("expected one critical result, got %v", results)
+		}
+	})
+}
+
+func TestAgentMetrics(t *testing.T) {
+	text := metricsText(t, agentCSV(t), nil)
+	wantMetrics(t, text,
+		`haproxy_agent_check_passed{host="",proxy="app",sv="app1",type="server"} 1`,
+		`haproxy_agent_check_passed{host="",proxy="app",sv="app2",type="server"} 0`,
+		`haproxy_agent_health{host="",proxy="app",sv="app1",type="server"} 4`,
+		`haproxy_agent_duration{host="",proxy="app",sv="app1",type="server"} 1`,
+	)
+	if strings.Contains(text, `haproxy_agent_check_passed{host="",proxy="app",sv="app3"`) {
+		t.Error("agent metric exported for server without agent check")
+	}
+}
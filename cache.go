@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// Frontends and backends that use HAProxy's cache report the number of cache
+// lookups and hits in cache_lookups and cache_hits.
+
+// cacheHitRatio returns the fraction of the cache lookups of row that were
+// hits, and a description of what it was computed from. When the previous run
+// is known, only the lookups since then are counted. ok is false if there
+// were no lookups.
+func cacheHitRatio(e *evaluation, row statRow) (ratio float64, description string, ok bool) {
+	lookups, ok := row.Float("cache_lookups")
+	if !ok {
+		return 0, "", false
+	}
+	hits, ok := row.Float("cache_hits")
+	if !ok {
+		return 0, "", false
+	}
+	since := ""
+	if e != nil {
+		dlookups, lok := e.delta(row, "cache_lookups")
+		dhits, hok := e.delta(row, "cache_hits")
+		if lok && hok {
+			lookups, hits, since = dlookups, dhits, " since the previous run"
+		}
+	}
+	if lookups == 0 {
+		return 0, "", false
+	}
+	return hits / lookups, fmt.Sprintf("%.0f of %.0f lookups%s", hits, lookups, since), true
+}
+
+// exportCacheMetrics exports the cache hit ratio of every frontend and
+// backend with cache lookups, since the previous run if it is known.
+func exportCacheMetrics(e *exporter, rows []statRow, previous *snapshot) {
+	gauge := e.gauge("haproxy_cache_hit_ratio", "cache hit ratio since the previous run, or since start", tags...)
+	eval := &evaluation{rows: rows, previous: previous}
+	for _, row := range rows {
+		if row.Type() != "frontend" && row.Type() != "backend" {
+			continue
+		}
+		if ratio, _, ok := cacheHitRatio(eval, row); ok {
+			gauge.WithLabelValues(rowLabels(row)...).Set(ratio)
+		}
+	}
+}
+
+// cacheRule warns about frontends and backends whose cache hit ratio is
+// below their --cache-hit-warning threshold.
+func cacheRule(e *evaluation) []result {
+	thresholds, _ := parseThresholds(config.CacheHitWarning, "hit_ratio")
+	if len(thresholds) == 0 {
+		return nil
+	}
+	var results []result
+	for _, row := range e.rows {
+		if row.Type() != "frontend" && row.Type() != "backend" {
+			continue
+		}
+		t, ok := findThreshold(thresholds, row, "hit_ratio")
+		if !ok {
+			continue
+		}
+		ratio, description, ok := cacheHitRatio(e, row)
+		if ok && ratio < t.value {
+			results = append(results, newResult(row, sensu.CheckStateWarning, "%s %s cache hit ratio is %.0f%% (%s), below %.0f%%", row.Type(), row.Proxy(), 100*ratio, description, 100*t.value))
+		}
+	}
+	return results
+}
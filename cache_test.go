@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func cacheCSV(t *testing.T) []byte {
+	csv := setColumns(t, testDataCSV, "static", "BACKEND", map[string]string{"cache_lookups": "1000", "cache_hits": "900"})
+	return setColumns(t, csv, "app", "BACKEND", map[string]string{"cache_lookups": "100", "cache_hits": "10"})
+}
+
+func TestCacheRule(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CacheHitWarning = []string{"hit_ratio=0.5"}
+	withConfig(cfg, func() {
+		results := cacheRule(&evaluation{rows: testRows(t, cacheCSV(t))})
+		if len(results) != 1 {
+			t.Fatalf("expected one result, got %v", results)
+		}
+		if got, want := results[0].Output, "backend app cache hit ratio is 10% (10 of 100 lookups), below 50%"; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+	})
+}
+
+func TestCacheRuleDelta(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CacheHitWarning = []string{"hit_ratio=0.5", "app:hit_ratio=0"}
+	withConfig(cfg, func() {
+		// The static backend missed all of its last 100 lookups.
+		rows := testRows(t, cacheCSV(t))
+		e := previousRun(rows, "BACKEND", map[string]float64{"cache_lookups": 100})
+		results := cacheRule(e)
+		if len(results) != 1 {
+			t.Fatalf("expected one result, got %v", results)
+		}
+		if got, want := results[0].Output, "backend static cache hit ratio is 0% (0 of 100 lookups since the previous run), below 50%"; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+	})
+}
+
+func TestCacheMetrics(t *testing.T) {
+	wantMetrics(t, metricsText(t, cacheCSV(t), nil),
+		`haproxy_cache_hit_ratio{host="",proxy="static",sv="BACKEND",type="backend"} 0.9`,
+		`haproxy_cache_hit_ratio{host="",proxy="app",sv="BACKEND",type="backend"} 0.1`,
+		`haproxy_cache_lookups{host="",proxy="static",sv="BACKEND",type="backend"} 1000`,
+		`haproxy_cache_hits{host="",proxy="static",sv="BACKEND",type="backend"} 900`,
+	)
+}
+
+func TestCacheMetricsDelta(t *testing.T) {
+	rows := testRows(t, cacheCSV(t))
+	e := newExporter()
+	// The backends missed all of their last 100 lookups.
+	exportCacheMetrics(e, rows, previousRun(rows, "BACKEND", map[string]float64{"cache_lookups": 100}).previous)
+	wantMetrics(t, exporterText(t, e),
+		`haproxy_cache_hit_ratio{host="",proxy="static",sv="BACKEND",type="backend"} 0`,
+		`haproxy_cache_hit_ratio{host="",proxy="app",sv="BACKEND",type="backend"} 0`,
+	)
+}
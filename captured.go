@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// With --show-errors, the invalid requests and responses that HAProxy
+// captured are read from the admin socket with show errors. HAProxy keeps the
+// last error of every proxy and direction, numbered with an event number that
+// grows across all proxies. The errors with a higher event number than the
+// last one seen by the previous run are new, and are listed in the output with
+// an excerpt, as a clue to what caused a spike in ereq or eresp. As only the
+// last error is kept, the number of errors of every proxy and direction since
+// the previous run is counted from ereq and eresp instead.
+
+// errorExcerptLength is the length that excerpts are truncated to.
+const errorExcerptLength = 120
+
+type capturedError struct {
+	Time      string
+	Type      string
+	Proxy     string
+	Direction string
+	Event     int
+	Source    string
+	Position  int
+	Excerpt   string
+	// Count is the number of errors of the proxy in the direction of the
+	// error since the previous run, or 0 if it is not known.
+	Count float64
+}
+
+type capturedErrors struct {
+	// Total is the number of errors captured since HAProxy started.
+	Total  float64
+	Errors []capturedError
+}
+
+var (
+	errorHeaderRE   = regexp.MustCompile(`^\[([^\]]+)\] (frontend|backend) (\S+) \(#-?\d+\): invalid (request|response)`)
+	errorEventRE    = regexp.MustCompile(`event #(\d+)`)
+	errorSourceRE   = regexp.MustCompile(`src ([^\s,]+)`)
+	errorPositionRE = regexp.MustCompile(`error at position (\d+)`)
+	errorDumpRE     = regexp.MustCompile(`^\s*\d{5}\+?\s+(.*)$`)
+)
+
+// errorColumns are the columns that count the errors of every direction.
+var errorColumns = map[string]string{
+	"request":  "ereq",
+	"response": "eresp",
+}
+
+// readErrors reads the captured errors from the admin socket at url.
+func readErrors(url *url.URL) (*capturedErrors, error) {
+	data, err := runCommand(url, "show errors")
+	if err != nil {
+		return nil, err
+	}
+	return parseErrors(data), nil
+}
+
+// parseErrors parses the output of show errors. Every error starts with a
+// line with its date, proxy and direction, followed by details such as its
+// event number, source and the position of the error, and a dump of the
+// request or response.
+func parseErrors(data []byte) *capturedErrors {
+	errs := &capturedErrors{}
+	var excerpt strings.Builder
+	flush := func() {
+		if len(errs.Errors) > 0 && excerpt.Len() > 0 {
+			errs.Errors[len(errs.Errors)-1].Excerpt = truncate(excerpt.String(), errorExcerptLength)
+		}
+		excerpt.Reset()
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "Total events captured") {
+			if i := strings.LastIndex(line, ":"); i >= 0 {
+				errs.Total, _ = strconv.ParseFloat(strings.TrimSpace(line[i+1:]), 64)
+			}
+			continue
+		}
+		if m := errorHeaderRE.FindStringSubmatch(line); m != nil {
+			flush()
+			errs.Errors = append(errs.Errors, capturedError{
+				Time:      m[1],
+				Type:      m[2],
+				Proxy:     m[3],
+				Direction: m[4],
+			})
+			continue
+		}
+		if len(errs.Errors) == 0 {
+			continue
+		}
+		e := &errs.Errors[len(errs.Errors)-1]
+		if m := errorDumpRE.FindStringSubmatch(line); m != nil {
+			excerpt.WriteString(m[1])
+			continue
+		}
+		if m := errorEventRE.FindStringSubmatch(line); m != nil {
+			e.Event, _ = strconv.Atoi(m[1])
+		}
+		if m := errorSourceRE.FindStringSubmatch(line); m != nil {
+			e.Source = m[1]
+		}
+		if m := errorPositionRE.FindStringSubmatch(line); m != nil {
+			e.Position, _ = strconv.Atoi(m[1])
+		}
+	}
+	flush()
+	return errs
+}
+
+// truncate shortens s to at most n bytes, marking that it was shortened.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
+
+// match reports whether the proxy of e passes f.
+func (e capturedError) match(f *filter) bool {
+	return f.matchName(e.Proxy, strings.ToUpper(e.Type), e.Type)
+}
+
+// lastEvent returns the highest event number of the captured errors.
+func (c *capturedErrors) lastEvent() float64 {
+	var last float64
+	for _, e := range c.Errors {
+		if float64(e.Event) > last {
+			last = float64(e.Event)
+		}
+	}
+	return last
+}
+
+func exportErrorMetrics(e *exporter, s *socketData) {
+	if s.errors == nil {
+		return
+	}
+	e.gauge("haproxy_errors_captured_total", "errors captured since HAProxy started").WithLabelValues().Set(s.errors.Total)
+}
+
+// exportErrorCountMetrics exports the number of errors of every frontend and
+// backend and direction since the previous run, with --show-errors.
+func exportErrorCountMetrics(e *exporter, rows []statRow, previous *snapshot) {
+	if !config.ShowErrors || previous == nil {
+		return
+	}
+	gauge := e.gauge("haproxy_errors_since_previous_run", "request or response errors since the previous run", "proxy", "type", "direction")
+	eval := &evaluation{rows: rows, previous: previous}
+	for _, row := range rows {
+		if row.Type() != "frontend" && row.Type() != "backend" {
+			continue
+		}
+		for direction, column := range errorColumns {
+			if count, ok := eval.delta(row, column); ok {
+				gauge.WithLabelValues(row.Proxy(), row.Type(), direction).Set(count)
+			}
+		}
+	}
+}
+
+// newErrors returns the errors that were captured since the previous run, or
+// all of them if there is no previous run or HAProxy was restarted since.
+func (e *evaluation) newErrors() []capturedError {
+	if e.socket == nil || e.socket.errors == nil {
+		return nil
+	}
+	var since float64
+	if previous, ok := e.socketCounters("errors"); ok && previous["last_event"] <= e.socket.errors.lastEvent() {
+		since = previous["last_event"]
+	}
+	var errs []capturedError
+	for _, err := range e.socket.errors.Errors {
+		if float64(err.Event) <= since {
+			continue
+		}
+		for _, row := range e.rows {
+			if row.Proxy() == err.Proxy && row.Type() == err.Type {
+				err.Count, _ = e.delta(row, errorColumns[err.Direction])
+			}
+		}
+		errs = append(errs, err)
+	}
+	return errs
+}
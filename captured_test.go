@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const errorsOutput = `Total events captured on [10/Oct/2023:10:00:00.123] : 5
+
+[10/Oct/2023:09:59:58.456] frontend fe (#2): invalid request
+  backend <NONE> (#-1), server <NONE> (#-1), event #4, src 192.0.2.10:51234
+  buffer starts at 0 (including 0 out), 16318 free,
+  len 64, wraps at 16336, error at position 5
+  H1 connection flags 0x00000000, H1 stream flags 0x00000012
+  H1 msg state MSG_RQMETH(2), H1 msg flags 0x00001400
+  H1 chunk len 0 bytes, HTTP body len 0 bytes, channel flags 0x00c08000 :
+
+  00000  GET /\x01 HTTP/1.1\r\n
+  00019  Host: example.com\r\n
+  00038  User-Agent: scanner/1.0 with a very long user agent that goes on and on and on and on and on\r\n
+
+[10/Oct/2023:09:50:00.000] backend be (#3): invalid response
+  frontend fe (#2), server srv1 (#1), event #2, src 192.0.2.11:40000
+  buffer starts at 0 (including 0 out), 16300 free,
+  len 20, wraps at 16336, error at position 9
+  00000  HTTP/1.1 999\r\n
+`
+
+func TestParseErrors(t *testing.T) {
+	errs := parseErrors([]byte(errorsOutput))
+	if errs.Total != 5 {
+		t.Errorf("bad total: %v", errs.Total)
+	}
+	if len(errs.Errors) != 2 {
+		t.Fatalf("expected two errors, got %v", errs.Errors)
+	}
+	e := errs.Errors[0]
+	if e.Type != "frontend" || e.Proxy != "fe" || e.Direction != "request" || e.Event != 4 || e.Source != "192.0.2.10:51234" || e.Position != 5 {
+		t.Errorf("bad error: %+v", e)
+	}
+	if !strings.HasPrefix(e.Excerpt, `GET /\x01 HTTP/1.1\r\nHost: example.com\r\n`) || len(e.Excerpt) != errorExcerptLength || !strings.HasSuffix(e.Excerpt, "...") {
+		t.Errorf("bad excerpt: %q", e.Excerpt)
+	}
+	if got, want := errs.Errors[1].Excerpt, `HTTP/1.1 999\r\n`; got != want {
+		t.Errorf("bad excerpt: got %q, want %q", got, want)
+	}
+	if errs.lastEvent() != 4 {
+		t.Errorf("bad last event: %v", errs.lastEvent())
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	socket := &socketData{errors: parseErrors([]byte(errorsOutput))}
+	if got := (&evaluation{socket: socket}).newErrors(); len(got) != 2 {
+		t.Errorf("expected all errors without a previous run, got %v", got)
+	}
+	previous := &snapshot{Time: time.Now(), Socket: map[string]map[string]float64{"errors": {"last_event": 3}}}
+	got := (&evaluation{socket: socket, previous: previous}).newErrors()
+	if len(got) != 1 || got[0].Proxy != "fe" {
+		t.Errorf("expected only the error of fe, got %v", got)
+	}
+	// HAProxy restarted, so the event numbers started over.
+	previous.Socket["errors"]["last_event"] = 100
+	if got := (&evaluation{socket: socket, previous: previous}).newErrors(); len(got) != 2 {
+		t.Errorf("expected all errors after a restart, got %v", got)
+	}
+}
+
+func TestWriteOutputErrors(t *testing.T) {
+	tmpl, err := parseOutputTemplate(defaultOutputTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := parseErrors([]byte(errorsOutput))
+	var buf bytes.Buffer
+	errs.Errors[1].Count = 3
+	if err := writeOutput(&buf, tmpl, &outputData{URL: "unix:///run/haproxy/admin.sock", Errors: errs.Errors[1:]}); err != nil {
+		t.Fatal(err)
+	}
+	want := `# OK: HAProxy unix:///run/haproxy/admin.sock
+# backend be captured an invalid response from 192.0.2.11:40000 at 10/Oct/2023:09:50:00.000, error at position 9 (3 errors since the previous run): HTTP/1.1 999\r\n
+`
+	if got := buf.String(); got != want {
+		t.Errorf("bad output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestErrorMetrics(t *testing.T) {
+	e := newExporter()
+	exportErrorMetrics(e, &socketData{errors: parseErrors([]byte(errorsOutput))})
+	wantMetrics(t, exporterText(t, e), "haproxy_errors_captured_total 5")
+}
+
+func TestErrorCountMetrics(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ShowErrors = true
+	withConfig(cfg, func() {
+		rows := testRows(t, setColumns(t, testDataCSV, "app", "BACKEND", map[string]string{"eresp": "7"}))
+		e := newExporter()
+		exportErrorCountMetrics(e, rows, previousRun(rows, "BACKEND", map[string]float64{"eresp": 3}).previous)
+		wantMetrics(t, exporterText(t, e),
+			`haproxy_errors_since_previous_run{direction="response",proxy="app",type="backend"} 3`,
+			`haproxy_errors_since_previous_run{direction="request",proxy="stats",type="frontend"} 0`,
+		)
+
+		e = newExporter()
+		exportErrorCountMetrics(e, rows, nil)
+		if text := exporterText(t, e); strings.Contains(text, "haproxy_errors_since_previous_run") {
+			t.Errorf("error counts exported without a previous run:\n%s", text)
+		}
+	})
+}
+
+func TestNewErrorsCount(t *testing.T) {
+	rows := testRows(t, setColumns(t, testDataCSV, "app", "BACKEND", map[string]string{"eresp": "7"}))
+	e := previousRun(rows, "BACKEND", map[string]float64{"eresp": 3})
+	e.socket = &socketData{errors: parseErrors([]byte(strings.Replace(errorsOutput, "backend be ", "backend app ", 1)))}
+	errs := e.newErrors()
+	if len(errs) != 2 || errs[1].Proxy != "app" || errs[1].Count != 3 {
+		t.Errorf("expected 3 new errors of app, got %+v", errs)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// With --ssl-certs, the certificates that HAProxy has loaded are read from
+// the admin socket: show ssl cert lists their files, and show ssl cert <file>
+// describes each of them. Since this is what HAProxy serves, rather than what
+// is on disk, it catches certificates that were renewed on disk without
+// HAProxy being reloaded.
+
+// certificate is a certificate loaded in HAProxy.
+type certificate struct {
+	File     string
+	Serial   string
+	Subject  string
+	Issuer   string
+	SANs     []string
+	NotAfter time.Time
+}
+
+// certTimeLayout is how OpenSSL, and so HAProxy, prints certificate times.
+const certTimeLayout = "Jan _2 15:04:05 2006 MST"
+
+// readCerts reads every certificate loaded in HAProxy from the admin socket
+// at url.
+func readCerts(url *url.URL) ([]certificate, error) {
+	data, err := runCommand(url, "show ssl cert")
+	if err != nil {
+		return nil, err
+	}
+	var certs []certificate
+	for _, file := range parseCertList(data) {
+		data, err := runCommand(url, "show ssl cert "+file)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := parseCert(data)
+		if err != nil {
+			return nil, fmt.Errorf("error reading certificate %s: %s", file, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// parseCertList parses the output of show ssl cert. Certificates of an
+// uncommitted transaction, prefixed with "*", are skipped.
+func parseCertList(data []byte) []string {
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "*") {
+			continue
+		}
+		files = append(files, line)
+	}
+	return files
+}
+
+// parseCert parses the output of show ssl cert <file>. Older versions of
+// HAProxy print notAfter, newer ones Not After.
+func parseCert(data []byte) (certificate, error) {
+	fields := parseFields(data)
+	cert := certificate{
+		File:    fields["Filename"],
+		Serial:  fields["Serial"],
+		Subject: fields["Subject"],
+		Issuer:  fields["Issuer"],
+	}
+	for _, san := range strings.Split(fields["Subject Alternative Name"], ",") {
+		if san = strings.TrimSpace(san); san != "" {
+			cert.SANs = append(cert.SANs, san)
+		}
+	}
+	notAfter, ok := fields["Not After"]
+	if !ok {
+		notAfter = fields["notAfter"]
+	}
+	t, err := time.Parse(certTimeLayout, notAfter)
+	if err != nil {
+		return cert, fmt.Errorf("invalid Not After %q: %s", notAfter, err)
+	}
+	cert.NotAfter = t
+	return cert, nil
+}
+
+// daysLeft returns the days until cert expires, which are negative once it
+// has expired.
+func (c certificate) daysLeft(now time.Time) float64 {
+	return c.NotAfter.Sub(now).Hours() / 24
+}
+
+// name describes the certificate by its file and names.
+func (c certificate) name() string {
+	if len(c.SANs) == 0 {
+		return fmt.Sprintf("certificate %s (%s)", c.File, c.Subject)
+	}
+	return fmt.Sprintf("certificate %s (%s)", c.File, strings.Join(c.SANs, ", "))
+}
+
+func exportCertMetrics(e *exporter, s *socketData) {
+	gauge := e.gauge("haproxy_ssl_cert_expiry_days", "days until the certificate expires", "file", "subject", "issuer")
+	now := time.Now()
+	for _, cert := range s.certs {
+		gauge.WithLabelValues(cert.File, cert.Subject, cert.Issuer).Set(cert.daysLeft(now))
+	}
+}
+
+// certRule judges the certificates loaded in HAProxy by the days until they
+// expire, and warns about certificates that differ from the file on disk.
+func certRule(e *evaluation) []result {
+	if !config.SSLCerts || e.socket == nil {
+		return nil
+	}
+	var results []result
+	for _, cert := range e.socket.certs {
+		days := cert.daysLeft(e.now)
+		output := fmt.Sprintf("%s expires in %.0f days, on %s, issued by %s", cert.name(), math.Floor(days), cert.NotAfter.Format("2006-01-02"), cert.Issuer)
+		if days < 0 {
+			output = fmt.Sprintf("%s expired %.0f days ago, on %s, issued by %s", cert.name(), math.Ceil(-days), cert.NotAfter.Format("2006-01-02"), cert.Issuer)
+		}
+		switch {
+		case days < float64(config.CertCriticalDays):
+			results = append(results, certResult(cert, sensu.CheckStateCritical, output))
+		case days < float64(config.CertWarningDays):
+			results = append(results, certResult(cert, sensu.CheckStateWarning, output))
+		}
+		if disk, ok := certOnDisk(cert.File); ok && !sameSerial(disk.SerialNumber.Text(16), cert.Serial) {
+			results = append(results, certResult(cert, sensu.CheckStateWarning, fmt.Sprintf("%s differs from the file on disk, which expires on %s; HAProxy needs a reload", cert.name(), disk.NotAfter.Format("2006-01-02"))))
+		}
+	}
+	return results
+}
+
+func certResult(cert certificate, status int, output string) result {
+	return result{
+		Subject: cert.File,
+		Type:    "certificate",
+		Status:  status,
+		Output:  output,
+	}
+}
+
+// certOnDisk reads the first certificate of the PEM file at path. ok is false
+// if the file cannot be read, for example because the check runs on another
+// host than HAProxy.
+func certOnDisk(path string) (cert *x509.Certificate, ok bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, false
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, false
+		}
+		return cert, true
+	}
+}
+
+// sameSerial compares hexadecimal serial numbers, ignoring case and leading
+// zeros.
+func sameSerial(a, b string) bool {
+	normalize := func(s string) string {
+		return strings.TrimLeft(strings.ToUpper(s), "0")
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+const certListOutput = `# transaction
+*/etc/haproxy/certs/new.pem
+# filename
+/etc/haproxy/certs/example.pem
+/etc/haproxy/certs/old.pem
+`
+
+const certOutput = `Filename: /etc/haproxy/certs/example.pem
+Status: Used
+Serial: 0D933C1B1089BF660AE5253A245BB388
+notBefore: Sep  9 00:00:00 2020 GMT
+notAfter: Nov  3 12:00:00 2026 GMT
+Subject Alternative Name: DNS:example.com, DNS:www.example.com
+Algorithm: RSA2048
+SHA1 FingerPrint: 0F1B3B8A0E7C3D2E8F0C6A1B2C3D4E5F60718293
+Subject: /CN=example.com
+Issuer: /C=US/O=Example CA/CN=Example Issuing CA
+Chain Subject: /C=US/O=Example CA/CN=Example Issuing CA
+Chain Issuer: /C=US/O=Example CA/CN=Example Root CA
+`
+
+func TestParseCertList(t *testing.T) {
+	got := parseCertList([]byte(certListOutput))
+	want := []string{"/etc/haproxy/certs/example.pem", "/etc/haproxy/certs/old.pem"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("bad files: got %v, want %v", got, want)
+	}
+}
+
+func TestParseCert(t *testing.T) {
+	cert, err := parseCert([]byte(certOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cert.Subject, "/CN=example.com"; got != want {
+		t.Errorf("bad subject: got %q, want %q", got, want)
+	}
+	if got, want := cert.Issuer, "/C=US/O=Example CA/CN=Example Issuing CA"; got != want {
+		t.Errorf("bad issuer: got %q, want %q", got, want)
+	}
+	if got, want := strings.Join(cert.SANs, ","), "DNS:example.com,DNS:www.example.com"; got != want {
+		t.Errorf("bad SANs: got %q, want %q", got, want)
+	}
+	if want := time.Date(2026, 11, 3, 12, 0, 0, 0, time.UTC); !cert.NotAfter.Equal(want) {
+		t.Errorf("bad Not After: got %s, want %s", cert.NotAfter, want)
+	}
+	newer := strings.Replace(certOutput, "notAfter:", "Not After:", 1)
+	if cert, err := parseCert([]byte(newer)); err != nil || cert.NotAfter.IsZero() {
+		t.Errorf("Not After not parsed: %v", err)
+	}
+	if _, err := parseCert([]byte("Filename: /x.pem\n")); err == nil {
+		t.Error("expected error for certificate without Not After")
+	}
+}
+
+func TestCertRule(t *testing.T) {
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	certs := []certificate{
+		{File: "/ok.pem", Subject: "/CN=ok", NotAfter: now.AddDate(0, 2, 0)},
+		{File: "/soon.pem", Subject: "/CN=soon", Issuer: "/CN=CA", NotAfter: now.Add(20*24*time.Hour + time.Hour)},
+		{File: "/sooner.pem", SANs: []string{"DNS:sooner"}, Issuer: "/CN=CA", NotAfter: now.Add(3 * 24 * time.Hour)},
+		{File: "/expired.pem", Subject: "/CN=expired", Issuer: "/CN=CA", NotAfter: now.Add(-36 * time.Hour)},
+	}
+	cfg := defaultConfig()
+	cfg.SSLCerts = true
+	cfg.CertWarningDays = 30
+	cfg.CertCriticalDays = 7
+	withConfig(cfg, func() {
+		results := certRule(&evaluation{now: now, socket: &socketData{certs: certs}})
+		want := []struct {
+			status int
+			output string
+		}{
+			{sensu.CheckStateWarning, "certificate /soon.pem (/CN=soon) expires in 20 days, on 2026-11-08, issued by /CN=CA"},
+			{sensu.CheckStateCritical, "certificate /sooner.pem (DNS:sooner) expires in 3 days, on 2026-10-22, issued by /CN=CA"},
+			{sensu.CheckStateCritical, "certificate /expired.pem (/CN=expired) expired 2 days ago, on 2026-10-17, issued by /CN=CA"},
+		}
+		if len(results) != len(want) {
+			t.Fatalf("bad results: got %v", results)
+		}
+		for i, r := range results {
+			if r.Status != want[i].status || r.Output != want[i].output {
+				t.Errorf("bad result %d:\ngot  %d %q\nwant %d %q", i, r.Status, r.Output, want[i].status, want[i].output)
+			}
+		}
+	})
+}
+
+func TestCertRuleDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	path := filepath.Join(dir, "example.pem")
+	writeTestCert(t, path, big.NewInt(0x2a), now.AddDate(1, 0, 0))
+	cfg := defaultConfig()
+	cfg.SSLCerts = true
+	cfg.CertWarningDays = 30
+	withConfig(cfg, func() {
+		loaded := certificate{File: path, Serial: "2A", NotAfter: now.AddDate(0, 6, 0)}
+		if results := certRule(&evaluation{now: now, socket: &socketData{certs: []certificate{loaded}}}); len(results) != 0 {
+			t.Errorf("expected no results for the certificate on disk, got %v", results)
+		}
+		loaded.Serial = "01"
+		results := certRule(&evaluation{now: now, socket: &socketData{certs: []certificate{loaded}}})
+		if len(results) != 1 || !strings.Contains(results[0].Output, "differs from the file on disk") {
+			t.Errorf("expected a result for the renewed certificate, got %v", results)
+		}
+	})
+}
+
+func writeTestCert(t *testing.T, path string, serial *big.Int, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    notAfter.AddDate(-1, 0, 0),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
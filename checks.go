@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// When a server is not UP, the result of its last health check and agent check
+// usually tells why. HAProxy reports the result as a short status code, which
+// is described here as in the HAProxy management guide.
+
+var checkStatusDescriptions = map[string]string{
+	"UNK":     "unknown",
+	"INI":     "initializing",
+	"SOCKERR": "socket error",
+	"L4OK":    "check passed on layer 4, no upper layers testing enabled",
+	"L4TOUT":  "layer 1-4 timeout",
+	"L4CON":   "layer 1-4 connection problem",
+	"L6OK":    "check passed on layer 6",
+	"L6TOUT":  "layer 6 (SSL) timeout",
+	"L6RSP":   "layer 6 invalid response, protocol error",
+	"L7OK":    "check passed on layer 7",
+	"L7OKC":   "check conditionally passed on layer 7, e.g. 404 with disable-on-404",
+	"L7TOUT":  "layer 7 (HTTP/SMTP) timeout",
+	"L7RSP":   "layer 7 invalid response, protocol error",
+	"L7STS":   "layer 7 response error, e.g. HTTP 5xx",
+	"PROCERR": "external check process error",
+	"PROCOK":  "external check process exited successfully",
+	"EXPIRED": "external check process timed out",
+}
+
+// describeCheckStatus returns the description of a check status code. HAProxy
+// prefixes the status of a check that is in progress with "* ".
+func describeCheckStatus(status string) string {
+	code := strings.TrimPrefix(status, "* ")
+	description, ok := checkStatusDescriptions[code]
+	if !ok {
+		return ""
+	}
+	if code != status {
+		description += ", check in progress"
+	}
+	return description
+}
+
+// checkColumns are the columns describing the last health check and agent
+// check of a server.
+var checkColumns = map[string]struct {
+	status, code, duration, desc, last string
+}{
+	"check": {"check_status", "check_code", "check_duration", "check_desc", "last_chk"},
+	"agent": {"agent_status", "agent_code", "agent_duration", "agent_desc", "last_agt"},
+}
+
+// checkDetail describes the last health check of a server, or its last agent
+// check if kind is "agent". It returns the empty string if the server has no
+// such check.
+func checkDetail(row statRow, kind string) string {
+	columns, ok := checkColumns[kind]
+	if !ok {
+		return ""
+	}
+	status := row.String(columns.status)
+	if status == "" {
+		return ""
+	}
+	detail := fmt.Sprintf("%s %s", kind, status)
+	if description := describeCheckStatus(status); description != "" {
+		detail += fmt.Sprintf(" (%s)", description)
+	}
+	if code := row.String(columns.code); code != "" {
+		detail += fmt.Sprintf(" code %s", code)
+	}
+	if duration, ok := row.Float(columns.duration); ok && duration >= 0 {
+		detail += fmt.Sprintf(" in %.0fms", duration)
+	}
+	var messages []string
+	for _, column := range []string{columns.desc, columns.last} {
+		if value := row.String(column); value != "" {
+			messages = append(messages, value)
+		}
+	}
+	if len(messages) > 0 {
+		detail += ": " + strings.Join(messages, ", ")
+	}
+	return detail
+}
+
+// checkDetails describes the last health check and agent check of a server.
+func checkDetails(row statRow) string {
+	var details []string
+	for _, kind := range []string{"check", "agent"} {
+		if detail := checkDetail(row, kind); detail != "" {
+			details = append(details, detail)
+		}
+	}
+	return strings.Join(details, "; ")
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestDescribeCheckStatus(t *testing.T) {
+	tests := map[string]string{
+		"L4CON":   "layer 1-4 connection problem",
+		"* L7STS": "layer 7 response error, e.g. HTTP 5xx, check in progress",
+		"FOO":     "",
+	}
+	for status, want := range tests {
+		if got := describeCheckStatus(status); got != want {
+			t.Errorf("bad description for %q: got %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestCheckDetails(t *testing.T) {
+	csv := setColumns(t, testDataCSV, "app", "app2", map[string]string{
+		"check_status":   "L7STS",
+		"check_code":     "503",
+		"check_duration": "12",
+		"check_desc":     "Layer7 wrong status",
+		"last_chk":       "Service Unavailable",
+		"agent_status":   "L4TOUT",
+		"agent_duration": "2001",
+		"agent_desc":     "Layer4 timeout",
+	})
+	for _, row := range testRows(t, csv) {
+		if row.Server() != "app2" {
+			continue
+		}
+		want := "check L7STS (layer 7 response error, e.g. HTTP 5xx) code 503 in 12ms: Layer7 wrong status, Service Unavailable; " +
+			"agent L4TOUT (layer 1-4 timeout) in 2001ms: Layer4 timeout"
+		if got := checkDetails(row); got != want {
+			t.Errorf("bad details:\ngot  %q\nwant %q", got, want)
+		}
+		return
+	}
+	t.Fatal("no row for app/app2")
+}
+
+func TestCheckDetailsNoCheck(t *testing.T) {
+	for _, row := range testRows(t, testDataCSV) {
+		if row.Type() == "backend" {
+			if got := checkDetails(row); got != "" {
+				t.Errorf("expected no details for backend, got %q", got)
+			}
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+// Frontends and backends that compress responses report the bytes fed to the
+// compressor in comp_in, the bytes it emitted in comp_out, the bytes that
+// bypassed it in comp_byp, and the number of compressed responses in
+// comp_rsp.
+
+// exportCompressionMetrics exports the compression ratio, the compressed size
+// as a fraction of the uncompressed size, and the percentage of bytes that
+// bypassed the compressor, for every frontend and backend that compressed
+// anything.
+func exportCompressionMetrics(e *exporter, rows []statRow, previous *snapshot) {
+	ratio := e.gauge("haproxy_compression_ratio", "compressor out bytes per in byte", tags...)
+	bypass := e.gauge("haproxy_compression_bypass_percent", "percentage of bytes that bypassed the compressor", tags...)
+	for _, row := range rows {
+		if row.Type() != "frontend" && row.Type() != "backend" {
+			continue
+		}
+		in, ok := row.Float("comp_in")
+		if !ok {
+			continue
+		}
+		if out, ok := row.Float("comp_out"); ok && in > 0 {
+			ratio.WithLabelValues(rowLabels(row)...).Set(out / in)
+		}
+		if byp, ok := row.Float("comp_byp"); ok && in+byp > 0 {
+			bypass.WithLabelValues(rowLabels(row)...).Set(100 * byp / (in + byp))
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressionMetrics(t *testing.T) {
+	csv := setColumns(t, testDataCSV, "main", "FRONTEND", map[string]string{
+		"comp_in":  "3000",
+		"comp_out": "750",
+		"comp_byp": "1000",
+		"comp_rsp": "12",
+	})
+	text := metricsText(t, csv, nil)
+	wantMetrics(t, text,
+		`haproxy_compression_ratio{host="",proxy="main",sv="FRONTEND",type="frontend"} 0.25`,
+		`haproxy_compression_bypass_percent{host="",proxy="main",sv="FRONTEND",type="frontend"} 25`,
+		`haproxy_comp_rsp{host="",proxy="main",sv="FRONTEND",type="frontend"} 12`,
+		"# HELP haproxy_comp_byp compressor bypassed bytes",
+	)
+	if strings.Contains(text, `haproxy_compression_ratio{host="",proxy="stats"`) {
+		t.Error("compression ratio exported for frontend without compression")
+	}
+}
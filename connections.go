@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// HAProxy 2.x keeps idle connections to servers for reuse by later requests.
+// connect and reuse count the requests that needed a new connection and that
+// reused an idle one. srv_icur is the number of idle connections a server has,
+// and src_ilim the most it may keep; once a pool is full, HAProxy closes
+// connections instead of keeping them.
+
+// reuseRatio returns the fraction of the connections of row that reused an
+// idle connection. ok is false if row has not used any connections.
+func reuseRatio(row statRow) (ratio float64, ok bool) {
+	connect, ok := row.Float("connect")
+	if !ok {
+		return 0, false
+	}
+	reuse, ok := row.Float("reuse")
+	if !ok || connect+reuse == 0 {
+		return 0, false
+	}
+	return reuse / (connect + reuse), true
+}
+
+// exportConnectionMetrics exports the connection reuse ratio of every
+// backend.
+func exportConnectionMetrics(e *exporter, rows []statRow, previous *snapshot) {
+	gauge := e.gauge("haproxy_connection_reuse_ratio", "connection reuse ratio", tags...)
+	for _, row := range rows {
+		if row.Type() != "backend" {
+			continue
+		}
+		if ratio, ok := reuseRatio(row); ok {
+			gauge.WithLabelValues(rowLabels(row)...).Set(ratio)
+		}
+	}
+}
+
+// idlePoolRule warns about servers whose idle connection pool is at least
+// config.IdlePoolWarning percent full.
+func idlePoolRule(e *evaluation) []result {
+	if config.IdlePoolWarning <= 0 {
+		return nil
+	}
+	var results []result
+	for _, row := range e.rows {
+		if row.Type() != "server" {
+			continue
+		}
+		idle, ok := row.Float("srv_icur")
+		if !ok {
+			continue
+		}
+		limit, ok := row.Float("src_ilim")
+		if !ok || limit <= 0 {
+			continue
+		}
+		if full := 100 * idle / limit; full >= config.IdlePoolWarning {
+			results = append(results, newResult(row, sensu.CheckStateWarning, "server %s/%s idle connection pool is %.0f%% full (%.0f of %.0f connections)", row.Proxy(), row.Server(), full, idle, limit))
+		}
+	}
+	return results
+}
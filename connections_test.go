@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func connectionsCSV(t *testing.T) []byte {
+	csv := setColumns(t, testDataCSV, "app", "BACKEND", map[string]string{"connect": "25", "reuse": "75"})
+	csv = setColumns(t, csv, "app", "app1", map[string]string{"srv_icur": "19", "src_ilim": "20"})
+	return setColumns(t, csv, "app", "app2", map[string]string{"srv_icur": "5", "src_ilim": "20"})
+}
+
+func TestIdlePoolRule(t *testing.T) {
+	withConfig(defaultConfig(), func() {
+		if results := idlePoolRule(&evaluation{rows: testRows(t, connectionsCSV(t))}); len(results) != 0 {
+			t.Errorf("expected no results when disabled, got %v", results)
+		}
+	})
+	cfg := defaultConfig()
+	cfg.IdlePoolWarning = 90
+	withConfig(cfg, func() {
+		results := idlePoolRule(&evaluation{rows: testRows(t, connectionsCSV(t))})
+		if len(results) != 1 {
+			t.Fatalf("expected one result, got %v", results)
+		}
+		if got, want := results[0].Output, "server app/app1 idle connection pool is 95% full (19 of 20 connections)"; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+	})
+}
+
+func TestConnectionMetrics(t *testing.T) {
+	text := metricsText(t, connectionsCSV(t), nil)
+	wantMetrics(t, text,
+		`haproxy_connection_reuse_ratio{host="",proxy="app",sv="BACKEND",type="backend"} 0.75`,
+		`haproxy_connect{host="",proxy="app",sv="BACKEND",type="backend"} 25`,
+		`haproxy_srv_icur{host="",proxy="app",sv="app1",type="server"} 19`,
+		`haproxy_src_ilim{host="",proxy="app",sv="app1",type="server"} 20`,
+	)
+	if strings.Contains(text, `haproxy_connection_reuse_ratio{host="",proxy="static"`) {
+		t.Error("reuse ratio exported for backend without connections")
+	}
+}
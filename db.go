@@ -3,13 +3,21 @@ package main
 import (
 	"bytes"
 	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
 )
 
-func createDB(data *statsData) (*sql.DB, error) {
+// createDB imports the rows of data that pass f into the metrics table of a
+// new in-memory database. A nil filter passes every row.
+func createDB(data *statsData, f *filter) (*sql.DB, error) {
 	db, err := sql.Open("sqlite", ":memory:")
 	if err != nil {
 		return nil, err
 	}
+	// every connection to :memory: opens a new, empty database, so the pool
+	// must never grow past the connection that the metrics are inserted with.
+	db.SetMaxOpenConns(1)
 	var buf bytes.Buffer
 	cols, err := data.ColumnNames()
 	if err != nil {
@@ -35,6 +43,9 @@ func createDB(data *statsData) (*sql.DB, error) {
 	}
 
 	for _, row := range rows {
+		if !f.match(newStatRow(cols, row)) {
+			continue
+		}
 		if _, err := db.Exec(buf.String(), row...); err != nil {
 			return nil, err
 		}
@@ -42,3 +53,104 @@ func createDB(data *statsData) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// statRow is a single row of the metrics table, keyed by column name.
+type statRow map[string]interface{}
+
+func newStatRow(cols []string, values []interface{}) statRow {
+	row := make(statRow, len(cols))
+	for i, value := range values {
+		row[cols[i]] = value
+	}
+	return row
+}
+
+// tableColumns returns the columns of the metrics table.
+func tableColumns(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT * FROM metrics LIMIT 0;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// loadRows reads every row of the metrics table.
+func loadRows(db *sql.DB) ([]statRow, error) {
+	rows, err := db.Query("SELECT * FROM metrics;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []statRow
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		args := make([]interface{}, len(cols))
+		for i := range values {
+			args[i] = &values[i]
+		}
+		if err := rows.Scan(args...); err != nil {
+			return nil, err
+		}
+		result = append(result, newStatRow(cols, values))
+	}
+	return result, rows.Err()
+}
+
+// String returns the value of column as a string, or the empty string if
+// the column is missing or empty.
+func (r statRow) String(column string) string {
+	switch value := r[column].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// Float returns the numeric value of column. ok is false if the column is
+// missing, empty or not a number.
+func (r statRow) Float(column string) (value float64, ok bool) {
+	switch value := r[column].(type) {
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// Proxy returns the proxy name of the row.
+func (r statRow) Proxy() string {
+	return r.String("pxname")
+}
+
+// Server returns the service name of the row; FRONTEND and BACKEND for
+// proxies, or the server name.
+func (r statRow) Server() string {
+	return r.String("svname")
+}
+
+// Type returns the instance type of the row: frontend, backend, server or
+// listener.
+func (r statRow) Type() string {
+	t, ok := r.Float("type")
+	if !ok || int(t) < 0 || int(t) >= len(instanceTypes) {
+		return ""
+	}
+	return instanceTypes[int(t)]
+}
@@ -1,12 +1,16 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
 
 func TestCreateDB(t *testing.T) {
 	data := &statsData{
 		data: testDataCSV,
 	}
-	db, err := createDB(data)
+	db, err := createDB(data, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -26,3 +30,111 @@ func TestCreateDB(t *testing.T) {
 		t.Errorf("bad count: got %d, want %d", got, want)
 	}
 }
+
+func testRows(t *testing.T, csv []byte) []statRow {
+	t.Helper()
+	db, err := createDB(&statsData{data: csv}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := loadRows(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+// metricsText returns the metrics output for csv and the socket data s, which
+// may be nil.
+func metricsText(t *testing.T, csv []byte, s *socketData) string {
+	t.Helper()
+	db, err := createDB(&statsData{data: csv}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var buf bytes.Buffer
+	if err := outputMetrics(&buf, db, s, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// exporterText returns the encoded metrics of e.
+func exporterText(t *testing.T, e *exporter) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := e.encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// wantMetrics reports every line of want that is missing from the metrics
+// text.
+func wantMetrics(t *testing.T, text string, want ...string) {
+	t.Helper()
+	for _, line := range want {
+		if !strings.Contains(text, line) {
+			t.Errorf("metrics missing %q", line)
+		}
+	}
+}
+
+func TestLoadRows(t *testing.T) {
+	rows := testRows(t, testDataCSV)
+	if got, want := len(rows), 9; got != want {
+		t.Fatalf("bad row count: got %d, want %d", got, want)
+	}
+	row := rows[4]
+	if got, want := row.Proxy(), "app"; got != want {
+		t.Errorf("bad proxy: got %q, want %q", got, want)
+	}
+	if got, want := row.Server(), "app1"; got != want {
+		t.Errorf("bad server: got %q, want %q", got, want)
+	}
+	if got, want := row.Type(), "server"; got != want {
+		t.Errorf("bad type: got %q, want %q", got, want)
+	}
+	if got, want := row.String("check_status"), "L4CON"; got != want {
+		t.Errorf("bad check_status: got %q, want %q", got, want)
+	}
+	if got, ok := row.Float("lastchg"); !ok || got != 72804 {
+		t.Errorf("bad lastchg: got %v, %v", got, ok)
+	}
+	if _, ok := row.Float("qlimit"); ok {
+		t.Error("empty column should not be a number")
+	}
+}
+
+// setColumns returns a copy of csv with columns of the row of proxy/server
+// set to the given values.
+func setColumns(t *testing.T, csv []byte, proxy, server string, values map[string]string) []byte {
+	t.Helper()
+	cols, err := (&statsData{data: csv}).ColumnNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(csv), "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, proxy+","+server+",") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		for column, value := range values {
+			var found bool
+			for j, col := range cols {
+				if col == column {
+					fields[j] = value
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("no such column: %s", column)
+			}
+		}
+		lines[i] = strings.Join(fields, ",")
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
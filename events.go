@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// In events mode, the check sends the results of the rules to the local Sensu
+// agent as separate events, one per frontend and backend and optionally one
+// per server, instead of judging the whole HAProxy instance with a single
+// status. Every proxy gets its own proxy entity, so that the events can be
+// routed and silenced per service. A frontend and a backend with the same name
+// share an entity, with a check each. With server entities, every backend
+// server gets its own proxy entity as well, named after the server and its
+// address, with a check per backend that the server is in. The results about
+// HAProxy itself rather than a proxy, such as certificates, stick tables,
+// resolvers, peers and memory pools, are sent as a single event on the
+// agent's own entity.
+
+const (
+	frontendCheckName = "haproxy-frontend"
+	backendCheckName  = "haproxy-backend"
+	serverCheckName   = "haproxy-server"
+	runtimeCheckName  = "haproxy-runtime"
+)
+
+var invalidNameRE = regexp.MustCompile(`[^\w\.\-\:]`)
+
+// entityName returns a valid Sensu entity name for a proxy.
+func entityName(proxy string) string {
+	return invalidNameRE.ReplaceAllString(config.EntityPrefix+proxy, "_")
+}
+
+// serverEntityName returns a valid Sensu entity name for a backend server,
+// made from its name and address.
+func serverEntityName(server statRow) string {
+	name := server.Server()
+	if addr := server.String("addr"); addr != "" {
+		name += "-" + addr
+	}
+	return invalidNameRE.ReplaceAllString(config.EntityPrefix+name, "_")
+}
+
+// entityLabels returns the labels for the entities of a backend and its
+// servers.
+func entityLabels(backend statRow) map[string]string {
+	labels := map[string]string{
+		"proxy": backend.Proxy(),
+	}
+	for _, column := range []string{"mode", "algo"} {
+		if value := backend.String(column); value != "" {
+			labels[column] = value
+		}
+	}
+	return labels
+}
+
+func newProxyEntity(name string, labels map[string]string) *corev2.Entity {
+	entity := corev2.NewEntity(corev2.ObjectMeta{Name: name, Labels: labels})
+	entity.EntityClass = corev2.EntityProxyClass
+	return entity
+}
+
+// newEvent returns an event for the check on entity. If entity is nil, the
+// agent sends the event on its own entity.
+func newEvent(entity *corev2.Entity, checkName string, status int, output string) *corev2.Event {
+	check := corev2.NewCheck(&corev2.CheckConfig{
+		ObjectMeta: corev2.ObjectMeta{Name: checkName},
+	})
+	if entity != nil {
+		check.ProxyEntityName = entity.Name
+	}
+	check.Status = uint32(status)
+	check.Output = output
+	check.Executed = time.Now().Unix()
+	return &corev2.Event{
+		Timestamp: check.Executed,
+		Entity:    entity,
+		Check:     check,
+	}
+}
+
+// eventOutput joins the outputs of results, which are expected to be ordered
+// worst first.
+func eventOutput(results []result) string {
+	outputs := make([]string, 0, len(results))
+	for _, r := range results {
+		outputs = append(outputs, r.Output)
+	}
+	return strings.Join(outputs, "\n")
+}
+
+// proxyResult reports whether r is about a proxy or one of its servers,
+// rather than about HAProxy itself.
+func proxyResult(r result) bool {
+	for _, t := range instanceTypes {
+		if r.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// runtimeCheck returns the name of the runtime check of the admin socket at
+// u, so that the runtime events of several sockets do not replace each other.
+func runtimeCheck(u *url.URL) string {
+	return invalidNameRE.ReplaceAllString(runtimeCheckName+"-"+strings.Trim(u.Host+u.Path, "/"), "_")
+}
+
+// runtimeEnabled reports whether any of the rules about HAProxy itself rather
+// than a proxy is enabled in config.
+func runtimeEnabled(config Config) bool {
+	return config.SSLCerts || config.OCSP || config.StickTables || config.Resolvers ||
+		config.Peers || config.Sessions || config.Pools || config.ShowErrors
+}
+
+// buildEvents returns an event for every frontend and backend in rows, and an
+// event for every server if config.ServerEvents or config.ServerEntities is
+// set. If runtime is not empty, an event named runtime for the results that
+// are not about a proxy is returned as well, even if there are none, so that
+// it resolves.
+func buildEvents(rows []statRow, results []result, runtime string) []*corev2.Event {
+	var events []*corev2.Event
+	if runtime != "" {
+		var runtimeResults []result
+		for _, r := range results {
+			if !proxyResult(r) {
+				runtimeResults = append(runtimeResults, r)
+			}
+		}
+		output := eventOutput(runtimeResults)
+		if output == "" {
+			output = "no problems found with the HAProxy runtime"
+		}
+		events = append(events, newEvent(nil, runtime, worst(runtimeResults), output))
+	}
+	for _, row := range rows {
+		if row.Type() == "frontend" {
+			var frontendResults []result
+			for _, r := range results {
+				if r.Type == "frontend" && r.Proxy == row.Proxy() {
+					frontendResults = append(frontendResults, r)
+				}
+			}
+			entity := newProxyEntity(entityName(row.Proxy()), entityLabels(row))
+			events = append(events, newEvent(entity, frontendCheckName, worst(frontendResults), eventOutput(frontendResults)))
+			continue
+		}
+		if row.Type() != "backend" {
+			continue
+		}
+		var backendResults []result
+		for _, r := range results {
+			if proxyResult(r) && r.Proxy == row.Proxy() && r.Type != "frontend" {
+				backendResults = append(backendResults, r)
+			}
+		}
+		labels := entityLabels(row)
+		entity := newProxyEntity(entityName(row.Proxy()), labels)
+		events = append(events, newEvent(entity, backendCheckName, worst(backendResults), eventOutput(backendResults)))
+		if !config.ServerEvents && !config.ServerEntities {
+			continue
+		}
+		for _, server := range rows {
+			if server.Type() != "server" || server.Proxy() != row.Proxy() {
+				continue
+			}
+			status, output := serverHealth(server)
+			serverResults := []result{newResult(server, status, "%s", output)}
+			for _, r := range backendResults {
+				if r.Type == "server" && r.Server == server.Server() {
+					serverResults = append(serverResults, r)
+				}
+			}
+			// A server entity can be in several backends, so its check
+			// is named after the backend rather than the server.
+			serverEntity := entity
+			checkName := invalidNameRE.ReplaceAllString(serverCheckName+"-"+server.Server(), "_")
+			if config.ServerEntities {
+				serverEntity = newProxyEntity(serverEntityName(server), labels)
+				checkName = invalidNameRE.ReplaceAllString(serverCheckName+"-"+row.Proxy(), "_")
+			}
+			events = append(events, newEvent(serverEntity, checkName, worst(serverResults), eventOutput(serverResults)))
+		}
+	}
+	return events
+}
+
+// postEvents sends events to the agent events API at config.EventsURL.
+func postEvents(events []*corev2.Event) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	for _, event := range events {
+		name := event.Check.Name
+		if event.Entity != nil {
+			name = event.Entity.Name + "/" + name
+		}
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Post(config.EventsURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("error posting event %s: %s", name, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("error posting event %s: agent responded with status %d", name, resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+// sendEvents evaluates e, scraped from u, and sends the resulting events to
+// the agent. The runtime event is only sent for admin sockets, and only if a
+// rule that it reports on is enabled.
+func sendEvents(e *evaluation, u *url.URL) error {
+	results := evaluate(e)
+	var runtime string
+	if e.socket != nil && runtimeEnabled(config) {
+		runtime = runtimeCheck(u)
+	}
+	return postEvents(buildEvents(e.rows, results, runtime))
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// agentServer stands in for the agent events API, recording every event.
+type agentServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	events []*corev2.Event
+}
+
+// adminSocketURL is the URL that the events in these tests are scraped from.
+var adminSocketURL = &url.URL{Scheme: "unix", Path: "/run/haproxy/admin.sock"}
+
+func newAgentServer() *agentServer {
+	a := new(agentServer)
+	a.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var event corev2.Event
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.mu.Lock()
+		a.events = append(a.events, &event)
+		a.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return a
+}
+
+func TestSendEvents(t *testing.T) {
+	agent := newAgentServer()
+	defer agent.Close()
+	cfg := defaultConfig()
+	cfg.Mode = "events"
+	cfg.EventsURL = agent.URL
+	cfg.EntityPrefix = "lb1-"
+	withConfig(cfg, func() {
+		if err := sendEvents(&evaluation{rows: testRows(t, statusCSV("UP", "UP", "UP", "DOWN"))}, adminSocketURL); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if got, want := len(agent.events), 4; got != want {
+		t.Fatalf("bad event count: got %d, want %d", got, want)
+	}
+	want := map[string]uint32{
+		"lb1-main/haproxy-frontend":  sensu.CheckStateOK,
+		"lb1-stats/haproxy-frontend": sensu.CheckStateOK,
+		"lb1-static/haproxy-backend": sensu.CheckStateCritical,
+		"lb1-app/haproxy-backend":    sensu.CheckStateWarning,
+	}
+	for _, event := range agent.events {
+		if got, want := event.Entity.EntityClass, corev2.EntityProxyClass; got != want {
+			t.Errorf("bad entity class: got %q, want %q", got, want)
+		}
+		name := event.Entity.Name + "/" + event.Check.Name
+		status, ok := want[name]
+		if !ok {
+			t.Errorf("unexpected event: %s", name)
+			continue
+		}
+		if got := event.Check.Status; got != status {
+			t.Errorf("bad status for %s: got %d, want %d", name, got, status)
+		}
+		if event.Check.Output == "" {
+			t.Errorf("empty output for %s", name)
+		}
+	}
+}
+
+func TestSendServerEvents(t *testing.T) {
+	agent := newAgentServer()
+	defer agent.Close()
+	cfg := defaultConfig()
+	cfg.Mode = "events"
+	cfg.EventsURL = agent.URL
+	cfg.ServerEvents = true
+	withConfig(cfg, func() {
+		if err := sendEvents(&evaluation{rows: testRows(t, statusCSV("UP", "UP", "UP", "DOWN"))}, adminSocketURL); err != nil {
+			t.Fatal(err)
+		}
+	})
+	statuses := make(map[string]uint32)
+	for _, event := range agent.events {
+		statuses[event.Entity.Name+"/"+event.Check.Name] = event.Check.Status
+	}
+	want := map[string]uint32{
+		"main/haproxy-frontend":        sensu.CheckStateOK,
+		"stats/haproxy-frontend":       sensu.CheckStateOK,
+		"static/haproxy-backend":       sensu.CheckStateCritical,
+		"static/haproxy-server-static": sensu.CheckStateCritical,
+		"app/haproxy-backend":          sensu.CheckStateWarning,
+		"app/haproxy-server-app1":      sensu.CheckStateOK,
+		"app/haproxy-server-app2":      sensu.CheckStateOK,
+		"app/haproxy-server-app3":      sensu.CheckStateOK,
+		"app/haproxy-server-app4":      sensu.CheckStateCritical,
+	}
+	if got, want := len(statuses), len(want); got != want {
+		t.Errorf("bad event count: got %d, want %d", got, want)
+	}
+	for key, status := range want {
+		if got, ok := statuses[key]; !ok || got != status {
+			t.Errorf("bad status for %s: got %d (%v), want %d", key, got, ok, status)
+		}
+	}
+}
+
+func TestSendEventsAgentError(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "nope", http.StatusBadRequest)
+	}))
+	defer agent.Close()
+	cfg := defaultConfig()
+	cfg.EventsURL = agent.URL
+	withConfig(cfg, func() {
+		if err := sendEvents(&evaluation{rows: testRows(t, testDataCSV)}, adminSocketURL); err == nil {
+			t.Error("expected non-nil error")
+		}
+	})
+}
+
+func TestSendServerEntityEvents(t *testing.T) {
+	agent := newAgentServer()
+	defer agent.Close()
+	cfg := defaultConfig()
+	cfg.Mode = "events"
+	cfg.EventsURL = agent.URL
+	cfg.ServerEntities = true
+	lines := strings.Split(string(statusCSV("UP", "UP", "UP", "DOWN")), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "app,app1,") {
+			line = strings.Replace(line, "app,app1,", "app,app 1,", 1)
+			lines[i] = strings.Replace(line, ",2,3,0,,,,,,http,", ",2,3,0,,,,10.0.0.1:8080,,http,", 1)
+		}
+	}
+	withConfig(cfg, func() {
+		if err := sendEvents(&evaluation{rows: testRows(t, []byte(strings.Join(lines, "\n")))}, adminSocketURL); err != nil {
+			t.Fatal(err)
+		}
+	})
+	entities := make(map[string]*corev2.Event)
+	for _, event := range agent.events {
+		entities[event.Entity.Name] = event
+	}
+	for _, name := range []string{"static", "app", "app_1-10.0.0.1:8080", "app2", "app3", "app4"} {
+		event, ok := entities[name]
+		if !ok {
+			t.Errorf("no event for entity %s", name)
+			continue
+		}
+		if got, want := event.Entity.Labels["mode"], "http"; got != want {
+			t.Errorf("bad mode label for %s: got %q, want %q", name, got, want)
+		}
+	}
+	if event, ok := entities["app4"]; ok {
+		if got, want := event.Check.Name, serverCheckName+"-app"; got != want {
+			t.Errorf("bad check name: got %q, want %q", got, want)
+		}
+		if got, want := event.Check.Status, uint32(sensu.CheckStateCritical); got != want {
+			t.Errorf("bad status: got %d, want %d", got, want)
+		}
+		if got, want := event.Entity.Labels["proxy"], "app"; got != want {
+			t.Errorf("bad proxy label: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSendRuntimeEvent(t *testing.T) {
+	agent := newAgentServer()
+	defer agent.Close()
+	cfg := defaultConfig()
+	cfg.Mode = "events"
+	cfg.EventsURL = agent.URL
+	cfg.Pools = true
+	cfg.PoolFailureWarning = 1
+	withConfig(cfg, func() {
+		socket := &socketData{pools: parsePools([]byte(poolsOutput))}
+		previous := &snapshot{Socket: map[string]map[string]float64{"pools/buffer": {"failures": 0}}}
+		e := &evaluation{
+			rows:     testRows(t, statusCSV("UP", "UP", "UP", "UP")),
+			previous: previous,
+			socket:   socket,
+		}
+		if err := sendEvents(e, adminSocketURL); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var runtime []*corev2.Event
+	for _, event := range agent.events {
+		if event.Check.Name == "haproxy-runtime-run_haproxy_admin.sock" {
+			runtime = append(runtime, event)
+		} else if strings.Contains(event.Check.Output, "pool") {
+			t.Errorf("pool result sent on %s/%s", event.Entity.Name, event.Check.Name)
+		}
+	}
+	if len(runtime) != 1 {
+		t.Fatalf("expected one runtime event, got %d", len(runtime))
+	}
+	if runtime[0].Entity != nil {
+		t.Errorf("runtime event sent on proxy entity %s", runtime[0].Entity.Name)
+	}
+	if got, want := runtime[0].Check.Status, uint32(sensu.CheckStateWarning); got != want {
+		t.Errorf("bad status: got %d, want %d", got, want)
+	}
+	if !strings.HasPrefix(runtime[0].Check.Output, "pool buffer failed 7 allocations") {
+		t.Errorf("bad output: %q", runtime[0].Check.Output)
+	}
+}
+
+func TestSendRuntimeEventDisabled(t *testing.T) {
+	agent := newAgentServer()
+	defer agent.Close()
+	cfg := defaultConfig()
+	cfg.Mode = "events"
+	cfg.EventsURL = agent.URL
+	withConfig(cfg, func() {
+		e := &evaluation{
+			rows:   testRows(t, statusCSV("UP", "UP", "UP", "UP")),
+			socket: &socketData{info: map[string]string{"Name": "HAProxy"}},
+		}
+		if err := sendEvents(e, adminSocketURL); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, event := range agent.events {
+		if event.Entity == nil {
+			t.Errorf("runtime event %s sent without runtime rules", event.Check.Name)
+		}
+	}
+}
+
+func TestRuntimeCheck(t *testing.T) {
+	for _, rawurl := range []string{"unix:///run/haproxy/admin.sock", "/run/haproxy/admin.sock", "unix:///run/haproxy/admin2.sock"} {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "haproxy-runtime-run_haproxy_admin.sock"
+		if strings.HasSuffix(rawurl, "2.sock") {
+			want = "haproxy-runtime-run_haproxy_admin2.sock"
+		}
+		if got := runtimeCheck(u); got != want {
+			t.Errorf("bad check name for %s: got %q, want %q", rawurl, got, want)
+		}
+	}
+}
+
+func TestBuildEventsSubjectNamedLikeBackend(t *testing.T) {
+	withConfig(defaultConfig(), func() {
+		results := []result{{Subject: "app", Type: "table", Status: sensu.CheckStateCritical, Output: "stick table app is 99% full"}}
+		for _, event := range buildEvents(testRows(t, statusCSV("UP", "UP", "UP", "UP")), results, runtimeCheckName) {
+			name := event.Check.Name
+			if event.Entity != nil {
+				name = event.Entity.Name + "/" + name
+			}
+			if strings.Contains(event.Check.Output, "stick table") != (name == runtimeCheckName) {
+				t.Errorf("bad output for %s: %q", name, event.Check.Output)
+			}
+		}
+	})
+}
+
+func TestSendServerEntityEventsSharedServer(t *testing.T) {
+	agent := newAgentServer()
+	defer agent.Close()
+	cfg := defaultConfig()
+	cfg.Mode = "events"
+	cfg.EventsURL = agent.URL
+	cfg.ServerEntities = true
+	csv := strings.Replace(string(statusCSV("UP", "UP", "UP", "DOWN")), "\nstatic,static,", "\nstatic,app4,", 1)
+	withConfig(cfg, func() {
+		if err := sendEvents(&evaluation{rows: testRows(t, []byte(csv))}, adminSocketURL); err != nil {
+			t.Fatal(err)
+		}
+	})
+	checks := make(map[string]int)
+	for _, event := range agent.events {
+		if event.Entity.Name == "app4" {
+			checks[event.Check.Name]++
+		}
+	}
+	for _, name := range []string{"haproxy-server-app", "haproxy-server-static"} {
+		if got := checks[name]; got != 1 {
+			t.Errorf("bad event count for app4/%s: got %d, want 1", name, got)
+		}
+	}
+	if got, want := len(checks), 2; got != want {
+		t.Errorf("bad check count for app4: got %d, want %d (%v)", got, want, checks)
+	}
+}
+
+func TestBuildEventsFrontend(t *testing.T) {
+	withConfig(defaultConfig(), func() {
+		rows := testRows(t, statusCSV("UP", "UP", "UP", "UP"))
+		results := evaluate(&evaluation{rows: rows})
+		results = append(results, result{Proxy: "main", Type: "frontend", Status: sensu.CheckStateCritical, Output: "frontend main had 90 failed SSL handshakes since the previous run"})
+		var found bool
+		for _, event := range buildEvents(rows, results, "") {
+			if strings.Contains(event.Check.Output, "SSL handshakes") {
+				if found {
+					t.Errorf("frontend result sent twice")
+				}
+				found = true
+				if got, want := event.Entity.Name+"/"+event.Check.Name, "main/haproxy-frontend"; got != want {
+					t.Errorf("frontend result sent on %s, want %s", got, want)
+				}
+				if got, want := event.Check.Status, uint32(sensu.CheckStateCritical); got != want {
+					t.Errorf("bad status: got %d, want %d", got, want)
+				}
+			}
+		}
+		if !found {
+			t.Error("frontend result not sent")
+		}
+	})
+}
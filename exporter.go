@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// An exporter collects the metrics for a single run of the check. Every
+// exporter has its own prometheus registry, so that exporters can be created
+// repeatedly, and concurrently for multiple targets, without sharing any state.
+// The metrics are rendered by encoding the gathered metric families directly,
+// rather than going through the prometheus HTTP handler.
+type exporter struct {
+	registry *prometheus.Registry
+	// labels are added to every metric of the exporter.
+	labels prometheus.Labels
+
+	mu     sync.Mutex
+	gauges map[string]*prometheus.GaugeVec
+}
+
+func newExporter() *exporter {
+	return newLabelledExporter(nil)
+}
+
+// newLabelledExporter returns an exporter that adds labels to every metric,
+// so that the metrics of multiple targets can be told apart once they are
+// gathered together.
+func newLabelledExporter(labels prometheus.Labels) *exporter {
+	return &exporter{
+		registry: prometheus.NewRegistry(),
+		labels:   labels,
+		gauges:   make(map[string]*prometheus.GaugeVec),
+	}
+}
+
+// gauge returns the gauge vector with the given name, creating and registering
+// it the first time it is asked for.
+func (e *exporter) gauge(name, help string, labels ...string) *prometheus.GaugeVec {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if gauge, ok := e.gauges[name]; ok {
+		return gauge
+	}
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        name,
+		Help:        help,
+		ConstLabels: e.labels,
+	}, labels)
+	e.registry.MustRegister(gauge)
+	e.gauges[name] = gauge
+	return gauge
+}
+
+// encode writes all of the exporter's metrics to w in the prometheus text
+// exposition format.
+func (e *exporter) encode(w io.Writer) error {
+	return encodeMetrics(w, e.registry)
+}
+
+// encodeMetrics writes the metrics gathered by g to w in the prometheus text
+// exposition format.
+func encodeMetrics(w io.Writer, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
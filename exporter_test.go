@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOutputMetricsRepeated(t *testing.T) {
+	db, err := createDB(&statsData{data: testDataCSV}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var first, second bytes.Buffer
+	if err := outputMetrics(&first, db, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := outputMetrics(&second, db, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if first.Len() == 0 {
+		t.Fatal("no metrics output")
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("output differs between runs")
+	}
+	want := `haproxy_smax{host="",proxy="stats",sv="FRONTEND",type="frontend"} 10`
+	if !strings.Contains(first.String(), want) {
+		t.Errorf("output missing %q", want)
+	}
+}
+
+func TestOutputMetricsParallel(t *testing.T) {
+	db, err := createDB(&statsData{data: testDataCSV}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var want bytes.Buffer
+	if err := outputMetrics(&want, db, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	outputs := make([]bytes.Buffer, 8)
+	errs := make([]error, len(outputs))
+	for i := range outputs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = outputMetrics(&outputs[i], db, nil, nil)
+		}(i)
+	}
+	wg.Wait()
+	for i := range outputs {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if !bytes.Equal(outputs[i].Bytes(), want.Bytes()) {
+			t.Errorf("output %d differs from sequential output", i)
+		}
+	}
+}
+
+func TestOutputMetricsMissingColumns(t *testing.T) {
+	csv := []byte("# pxname,svname,scur,type,addr,\nweb,FRONTEND,3,0,,\n")
+	db, err := createDB(&statsData{data: csv}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var buf bytes.Buffer
+	if err := outputMetrics(&buf, db, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `haproxy_scur{host="",proxy="web",sv="FRONTEND",type="frontend"} 3`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("output missing %q:\n%s", want, buf.String())
+	}
+}
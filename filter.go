@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Filters select the proxies and servers that are monitored. Rows that do not
+// pass the filter are never inserted into the metrics table, so they are
+// neither exported nor evaluated.
+//
+// A pattern is a glob, unless it is enclosed in slashes, in which case it is a
+// regular expression. Filters can be configured for all URLs with the include
+// and exclude options, and for a single URL with query parameters of the same
+// names, for example unix:///run/haproxy/admin.sock?exclude-proxy=stats.
+
+// filterFields maps the names used in the filter options to the columns they
+// filter on.
+var filterFields = map[string]string{
+	"proxy":  "pxname",
+	"server": "svname",
+	"type":   "type",
+}
+
+type pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func parsePattern(s string) (pattern, error) {
+	if len(s) > 1 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		re, err := regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return pattern{}, fmt.Errorf("invalid regular expression %q: %s", s, err)
+		}
+		return pattern{re: re}, nil
+	}
+	if _, err := path.Match(s, ""); err != nil {
+		return pattern{}, fmt.Errorf("invalid glob %q: %s", s, err)
+	}
+	return pattern{glob: s}, nil
+}
+
+func (p pattern) match(s string) bool {
+	if p.re != nil {
+		return p.re.MatchString(s)
+	}
+	ok, _ := path.Match(p.glob, s)
+	return ok
+}
+
+type filter struct {
+	include map[string][]pattern
+	exclude map[string][]pattern
+}
+
+// newFilter returns the filter for u, made from the configured filters and the
+// filter query parameters of u.
+func newFilter(config Config, u *url.URL) (*filter, error) {
+	f := &filter{
+		include: make(map[string][]pattern),
+		exclude: make(map[string][]pattern),
+	}
+	configured := map[string][]string{
+		"include-proxy":  config.IncludeProxy,
+		"exclude-proxy":  config.ExcludeProxy,
+		"include-server": config.IncludeServer,
+		"exclude-server": config.ExcludeServer,
+		"include-type":   config.IncludeType,
+		"exclude-type":   config.ExcludeType,
+	}
+	query := u.Query()
+	for name, patterns := range configured {
+		patterns = append(patterns, query[name]...)
+		parts := strings.SplitN(name, "-", 2)
+		patternMap := f.include
+		if parts[0] == "exclude" {
+			patternMap = f.exclude
+		}
+		for _, s := range patterns {
+			p, err := parsePattern(s)
+			if err != nil {
+				return nil, err
+			}
+			patternMap[parts[1]] = append(patternMap[parts[1]], p)
+		}
+	}
+	return f, nil
+}
+
+// isFilterParam reports whether a query parameter configures a filter.
+func isFilterParam(name string) bool {
+	parts := strings.SplitN(name, "-", 2)
+	if len(parts) != 2 || (parts[0] != "include" && parts[0] != "exclude") {
+		return false
+	}
+	_, ok := filterFields[parts[1]]
+	return ok
+}
+
+// match reports whether row passes the filter.
+func (f *filter) match(row statRow) bool {
+	return f.matchName(row.Proxy(), row.Server(), row.Type())
+}
+
+// matchName reports whether the proxy or server of the given type passes the
+// filter. Server filters only apply to servers, so that excluding servers does
+// not exclude their backend.
+func (f *filter) matchName(proxy, server, typ string) bool {
+	if f == nil {
+		return true
+	}
+	values := map[string]string{
+		"proxy":  proxy,
+		"server": server,
+		"type":   typ,
+	}
+	for field := range filterFields {
+		if field == "server" && typ != "server" {
+			continue
+		}
+		value := values[field]
+		if include := f.include[field]; len(include) > 0 && !matchAny(include, value) {
+			return false
+		}
+		if matchAny(f.exclude[field], value) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(patterns []pattern, s string) bool {
+	for _, p := range patterns {
+		if p.match(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"testing"
+)
+
+func filteredRows(t *testing.T, cfg Config, rawURL string) []string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newFilter(cfg, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := createDB(&statsData{data: testDataCSV}, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := loadRows(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, row := range rows {
+		names = append(names, row.Proxy()+"/"+row.Server())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		url  string
+		want []string
+	}{
+		{
+			name: "no filter",
+			url:  "unix:///run/haproxy/admin.sock",
+			want: []string{"app/BACKEND", "app/app1", "app/app2", "app/app3", "app/app4", "main/FRONTEND", "static/BACKEND", "static/static", "stats/FRONTEND"},
+		},
+		{
+			name: "exclude proxy glob",
+			cfg:  Config{ExcludeProxy: []string{"st*"}},
+			url:  "unix:///run/haproxy/admin.sock",
+			want: []string{"app/BACKEND", "app/app1", "app/app2", "app/app3", "app/app4", "main/FRONTEND"},
+		},
+		{
+			name: "include type",
+			cfg:  Config{IncludeType: []string{"frontend", "backend"}},
+			url:  "unix:///run/haproxy/admin.sock",
+			want: []string{"app/BACKEND", "main/FRONTEND", "static/BACKEND", "stats/FRONTEND"},
+		},
+		{
+			name: "exclude server regex keeps backend",
+			cfg:  Config{ExcludeServer: []string{"/^app[12]$/"}, IncludeProxy: []string{"app"}},
+			url:  "unix:///run/haproxy/admin.sock",
+			want: []string{"app/BACKEND", "app/app3", "app/app4"},
+		},
+		{
+			name: "url parameters",
+			cfg:  Config{ExcludeProxy: []string{"main"}},
+			url:  "http://localhost/stats?exclude-proxy=stats&exclude-type=server&exclude-type=backend",
+			want: []string(nil),
+		},
+		{
+			name: "url parameters add to options",
+			cfg:  Config{ExcludeProxy: []string{"main"}},
+			url:  "http://localhost/stats?exclude-proxy=app&include-type=frontend&include-type=backend",
+			want: []string{"static/BACKEND", "stats/FRONTEND"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := filteredRows(t, test.cfg, test.url)
+			if len(got) != len(test.want) {
+				t.Fatalf("bad rows: got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("bad rows: got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterInvalidPattern(t *testing.T) {
+	u, err := url.Parse("unix:///run/haproxy/admin.sock?include-proxy=/(/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFilter(Config{}, u); err == nil {
+		t.Error("expected non-nil error for invalid regex")
+	}
+	if _, err := newFilter(Config{ExcludeServer: []string{"["}}, &url.URL{}); err == nil {
+		t.Error("expected non-nil error for invalid glob")
+	}
+}
+
+func TestStripFilterParams(t *testing.T) {
+	u, err := url.Parse("https://lb.example.com/stats?exclude-proxy=stats&scope=app&include-type=backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stripFilterParams(u).String(), "https://lb.example.com/stats?scope=app"; got != want {
+		t.Errorf("bad url: got %q, want %q", got, want)
+	}
+}
+
+func TestFilterSocketData(t *testing.T) {
+	f, err := newFilter(Config{ExcludeProxy: []string{"static", "be"}, ExcludeServer: []string{"app2"}}, &url.URL{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socketData{
+		sessions: parseSessions([]byte(sessionsOutput)),
+		errors:   parseErrors([]byte(errorsOutput)),
+	}
+	s.applyFilter(f)
+	var sessions []string
+	for _, session := range s.sessions {
+		sessions = append(sessions, session.Frontend+"/"+session.Backend+"/"+session.Server)
+	}
+	if got, want := fmt.Sprint(sessions), "[http/app/app1 http/app/app1 GLOBAL/<NONE>/<NONE>]"; got != want {
+		t.Errorf("bad sessions: got %s, want %s", got, want)
+	}
+	if len(s.errors.Errors) != 1 || s.errors.Errors[0].Proxy != "fe" {
+		t.Errorf("expected only the error of fe, got %v", s.errors.Errors)
+	}
+	(*socketData)(nil).applyFilter(f)
+}
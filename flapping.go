@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// A server whose health checks keep failing and recovering causes errors for
+// users long before it stays DOWN. chkdown counts the transitions of a server
+// from UP to DOWN, so comparing it with the previous run gives the number of
+// times the server went down since then. As the previous run can be any time
+// ago, the transitions are counted per config.FlapInterval: transitions over a
+// longer time are averaged over the interval.
+
+// flappingRule warns about servers that went DOWN at least config.FlapWarning
+// times per interval since the previous run, and is critical about servers
+// that did so at least config.FlapCritical times.
+func flappingRule(e *evaluation) []result {
+	interval := time.Duration(config.FlapInterval) * time.Second
+	var results []result
+	for _, row := range e.rows {
+		if row.Type() != "server" {
+			continue
+		}
+		transitions, ok := e.delta(row, "chkdown")
+		if !ok {
+			continue
+		}
+		rate := transitions
+		if elapsed := e.elapsed(); interval > 0 && elapsed > interval {
+			rate = transitions * interval.Seconds() / elapsed.Seconds()
+		}
+		var status int
+		switch {
+		case config.FlapCritical > 0 && rate >= float64(config.FlapCritical):
+			status = sensu.CheckStateCritical
+		case config.FlapWarning > 0 && rate >= float64(config.FlapWarning):
+			status = sensu.CheckStateWarning
+		default:
+			continue
+		}
+		results = append(results, newResult(row, status, "%s", flappingOutput(e, row, transitions, rate)))
+	}
+	return results
+}
+
+func flappingOutput(e *evaluation, row statRow, transitions, rate float64) string {
+	output := fmt.Sprintf("server %s/%s is flapping: went DOWN %.0f times", row.Proxy(), row.Server(), transitions)
+	if failed, ok := e.delta(row, "chkfail"); ok {
+		output += fmt.Sprintf(" with %.0f failed checks", failed)
+	}
+	output += fmt.Sprintf(" in %s", e.elapsed().Round(time.Second))
+	if rate != transitions {
+		output += fmt.Sprintf(" (%.1f per %s)", rate, time.Duration(config.FlapInterval)*time.Second)
+	}
+	if lastchg, ok := row.Float("lastchg"); ok {
+		output += fmt.Sprintf(", now %s for %s", row.String("status"), time.Duration(lastchg)*time.Second)
+	}
+	health, ok1 := row.Float("check_health")
+	rise, ok2 := row.Float("check_rise")
+	fall, ok3 := row.Float("check_fall")
+	if ok1 && ok2 && ok3 {
+		// health counts from 0 to rise+fall-1, the server is up from rise.
+		output += fmt.Sprintf(", health %.0f/%.0f (rise %.0f, fall %.0f)", health, rise+fall-1, rise, fall)
+	}
+	if detail := checkDetail(row, "check"); detail != "" {
+		output += ", last " + detail
+	}
+	return output
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// previousRun returns an evaluation of rows against a snapshot of the same
+// rows taken a minute earlier, with the given counters of the named server
+// decreased by the given amounts.
+func previousRun(rows []statRow, server string, decrease map[string]float64) *evaluation {
+	now := time.Now()
+	previous := newSnapshot(rows, now.Add(-time.Minute))
+	for _, row := range rows {
+		if row.Server() != server {
+			continue
+		}
+		for column, amount := range decrease {
+			previous.Rows[rowKey(row)][column] -= amount
+		}
+	}
+	return &evaluation{rows: rows, previous: previous, now: now}
+}
+
+func TestFlappingRule(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.FlapWarning = 2
+	cfg.FlapCritical = 5
+	rows := testRows(t, testDataCSV)
+	tests := []struct {
+		name     string
+		chkdown  float64
+		wantLen  int
+		wantStat int
+	}{
+		{"stable", 1, 0, 0},
+		{"warning", 3, 1, sensu.CheckStateWarning},
+		{"critical", 5, 1, sensu.CheckStateCritical},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(cfg, func() {
+				e := previousRun(rows, "app2", map[string]float64{"chkdown": test.chkdown, "chkfail": 10})
+				results := flappingRule(e)
+				if got, want := len(results), test.wantLen; got != want {
+					t.Fatalf("bad result count: got %d, want %d", got, want)
+				}
+				if test.wantLen == 0 {
+					return
+				}
+				if got, want := results[0].Status, test.wantStat; got != want {
+					t.Errorf("bad status: got %d, want %d", got, want)
+				}
+				if got, want := results[0].Server, "app2"; got != want {
+					t.Errorf("bad server: got %q, want %q", got, want)
+				}
+			})
+		})
+	}
+}
+
+func TestFlappingOutput(t *testing.T) {
+	withConfig(defaultConfig(), func() {
+		e := previousRun(testRows(t, testDataCSV), "app2", map[string]float64{"chkdown": 3, "chkfail": 10})
+		results := flappingRule(e)
+		if len(results) != 1 {
+			t.Fatalf("bad result count: got %d, want 1", len(results))
+		}
+		want := "server app/app2 is flapping: went DOWN 3 times with 10 failed checks in 1m0s, now DOWN for 20h13m23s, health 0/4 (rise 2, fall 3), last check L4CON (layer 1-4 connection problem) in 0ms: Layer4 connection problem, Connection refused"
+		if got := results[0].Output; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+	})
+}
+
+func TestFlappingRuleWithoutState(t *testing.T) {
+	withConfig(defaultConfig(), func() {
+		if results := flappingRule(&evaluation{rows: testRows(t, testDataCSV)}); len(results) != 0 {
+			t.Errorf("expected no results without state, got %v", results)
+		}
+	})
+}
+
+func TestFlappingRuleCounterReset(t *testing.T) {
+	withConfig(defaultConfig(), func() {
+		e := previousRun(testRows(t, testDataCSV), "app2", map[string]float64{"chkdown": -10})
+		if results := flappingRule(e); len(results) != 0 {
+			t.Errorf("expected no results after a counter reset, got %v", results)
+		}
+	})
+}
+
+func TestFlappingRuleOldState(t *testing.T) {
+	withConfig(defaultConfig(), func() {
+		e := previousRun(testRows(t, testDataCSV), "app2", map[string]float64{"chkdown": 6})
+		e.previous.Time = e.now.Add(-2 * time.Hour)
+		if results := flappingRule(e); len(results) != 0 {
+			t.Errorf("expected no results for transitions over two hours, got %v", results)
+		}
+
+		e.previous.Time = e.now.Add(-20 * time.Minute)
+		results := flappingRule(e)
+		if len(results) != 1 || results[0].Status != sensu.CheckStateWarning {
+			t.Fatalf("expected one warning, got %v", results)
+		}
+		want := "server app/app2 is flapping: went DOWN 6 times with 0 failed checks in 20m0s (3.0 per 10m0s)"
+		if got := results[0].Output; !strings.HasPrefix(got, want) {
+			t.Errorf("bad output:\ngot  %q\nwant %q...", got, want)
+		}
+	})
+}
@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/docker/go-units v0.4.0
 	github.com/prometheus/client_golang v1.12.1
+	github.com/prometheus/common v0.32.1
 	github.com/sensu/sensu-go/api/core/v2 v2.3.0
 	github.com/sensu/sensu-plugin-sdk v0.15.0
 	modernc.org/sqlite v1.14.6
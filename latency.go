@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// HAProxy reports the average queue, connect, response and total times of the
+// last 1024 requests of every backend and server in milliseconds, and the
+// maximum times in the *_max columns. The latency thresholds are configured in
+// seconds.
+
+var latencyColumns = []string{"qtime", "ctime", "rtime", "ttime"}
+
+var latencyNames = map[string]string{
+	"qtime": "queue time",
+	"ctime": "connect time",
+	"rtime": "response time",
+	"ttime": "total time",
+}
+
+// latencyRule compares the average times of backends and servers with the
+// latency thresholds, and their maximum times with the latency ceilings.
+func latencyRule(e *evaluation) []result {
+	warning, _ := parseThresholds(config.LatencyWarning, latencyColumns...)
+	critical, _ := parseThresholds(config.LatencyCritical, latencyColumns...)
+	ceiling, _ := parseThresholds(config.LatencyCeiling, latencyColumns...)
+	var results []result
+	for _, row := range e.rows {
+		if row.Type() != "backend" && row.Type() != "server" {
+			continue
+		}
+		for _, column := range latencyColumns {
+			if r, ok := latencyResult(row, column, column, warning, critical); ok {
+				results = append(results, r)
+			}
+			if r, ok := latencyResult(row, column, column+"_max", nil, ceiling); ok {
+				results = append(results, r)
+			}
+		}
+	}
+	return results
+}
+
+// latencyResult compares the milliseconds in column of row with the
+// thresholds named name, which are in seconds.
+func latencyResult(row statRow, name, column string, warning, critical []threshold) (result, bool) {
+	ms, ok := row.Float(column)
+	if !ok {
+		return result{}, false
+	}
+	seconds := ms / 1000
+	if t, ok := findThreshold(critical, row, name); ok && seconds > t.value {
+		return newResult(row, sensu.CheckStateCritical, "%s %s is %s, above %s", latencySubject(row), latencyLabel(column), formatSeconds(seconds), formatSeconds(t.value)), true
+	}
+	if t, ok := findThreshold(warning, row, name); ok && seconds > t.value {
+		return newResult(row, sensu.CheckStateWarning, "%s %s is %s, above %s", latencySubject(row), latencyLabel(column), formatSeconds(seconds), formatSeconds(t.value)), true
+	}
+	return result{}, false
+}
+
+func latencySubject(row statRow) string {
+	if row.Type() == "server" {
+		return fmt.Sprintf("server %s/%s", row.Proxy(), row.Server())
+	}
+	return fmt.Sprintf("backend %s", row.Proxy())
+}
+
+func latencyLabel(column string) string {
+	if name := strings.TrimSuffix(column, "_max"); name != column {
+		return "max " + latencyNames[name]
+	}
+	return "average " + latencyNames[column]
+}
+
+func formatSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3fs", seconds)
+}
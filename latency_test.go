@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestLatencyRule(t *testing.T) {
+	csv := setColumns(t, testDataCSV, "app", "BACKEND", map[string]string{"rtime": "400", "rtime_max": "6000"})
+	csv = setColumns(t, csv, "app", "app1", map[string]string{"rtime": "1200", "qtime": "50"})
+	cfg := defaultConfig()
+	cfg.LatencyWarning = []string{"rtime=0.3", "qtime=0.1"}
+	cfg.LatencyCritical = []string{"app/app1:rtime=1"}
+	cfg.LatencyCeiling = []string{"rtime=5"}
+	withConfig(cfg, func() {
+		results := latencyRule(&evaluation{rows: testRows(t, csv)})
+		want := map[string]result{
+			"app/BACKEND/warning": {Status: sensu.CheckStateWarning, Output: "backend app average response time is 0.400s, above 0.300s"},
+			"app/BACKEND/ceiling": {Status: sensu.CheckStateCritical, Output: "backend app max response time is 6.000s, above 5.000s"},
+			"app/app1/critical":   {Status: sensu.CheckStateCritical, Output: "server app/app1 average response time is 1.200s, above 1.000s"},
+		}
+		if got, want := len(results), len(want); got != want {
+			t.Fatalf("bad result count: got %d, want %d: %v", got, want, results)
+		}
+		for _, r := range results {
+			var found bool
+			for _, w := range want {
+				if r.Status == w.Status && r.Output == w.Output {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("unexpected result: %+v", r)
+			}
+		}
+	})
+}
+
+func TestLatencyRuleNoThresholds(t *testing.T) {
+	csv := setColumns(t, testDataCSV, "app", "BACKEND", map[string]string{"rtime": "400000"})
+	withConfig(defaultConfig(), func() {
+		if results := latencyRule(&evaluation{rows: testRows(t, csv)}); len(results) != 0 {
+			t.Errorf("expected no results, got %v", results)
+		}
+	})
+}
@@ -6,12 +6,16 @@ import (
 	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
+	"path"
 	"regexp"
 	"strconv"
+	"text/template"
+	"time"
 
 	_ "modernc.org/sqlite"
 
@@ -22,13 +26,71 @@ import (
 // Config represents the check plugin config.
 type Config struct {
 	sensu.PluginConfig
-	URLs               []string
-	AdminUser          string
-	AdminPass          string
-	TLSCA              string
-	TLSCert            string
-	TLSKey             string
-	InsecureSkipVerify bool
+	URLs                  []string
+	AdminUser             string
+	AdminPass             string
+	TLSCA                 string
+	TLSCert               string
+	TLSKey                string
+	InsecureSkipVerify    bool
+	Mode                  string
+	ListenAddress         string
+	CacheSeconds          int
+	EventsURL             string
+	ServerEvents          bool
+	ServerEntities        bool
+	EntityPrefix          string
+	AvailableWarning      float64
+	AvailableCritical     float64
+	MaxMaintenanceSeconds int
+	StateFile             string
+	FlapWarning           int
+	FlapCritical          int
+	FlapInterval          int
+	LatencyWarning        []string
+	LatencyCritical       []string
+	LatencyCeiling        []string
+	AgentCritical         bool
+	MinWeightPercent      float64
+	ExpectedWeight        []string
+	IdlePoolWarning       float64
+	CacheHitWarning       []string
+	SSLHandshakeWarning   []string
+	SSLHandshakeCritical  []string
+	ProtocolErrorWarning  []string
+	ProtocolErrorCritical []string
+	SSLCerts              bool
+	CertWarningDays       int
+	CertCriticalDays      int
+	OCSP                  bool
+	OCSPCerts             []string
+	OCSPWarningHours      int
+	StickTables           bool
+	TableWarning          float64
+	TableCritical         float64
+	TableTop              int
+	TableTopCounter       string
+	Resolvers             bool
+	ResolverWarning       []string
+	ResolverCritical      []string
+	Peers                 bool
+	ShowErrors            bool
+	Sessions              bool
+	SessionAge            int
+	OldSessionsWarning    int
+	OldSessionsCritical   int
+	Pools                 bool
+	Activity              bool
+	PoolFailureWarning    int
+	PoolFailureCritical   int
+	OutputTemplate        string
+	Alert                 bool
+	IncludeProxy          []string
+	ExcludeProxy          []string
+	IncludeServer         []string
+	ExcludeServer         []string
+	IncludeType           []string
+	ExcludeType           []string
 }
 
 var (
@@ -96,6 +158,466 @@ var (
 			Usage:    "disable TLS hostname verification (DANGEROUS!)",
 			Value:    &config.InsecureSkipVerify,
 		},
+		&sensu.PluginConfigOption{
+			Path:     "mode",
+			Env:      "HAPROXY_MODE",
+			Argument: "mode",
+			Default:  "check",
+			Usage:    "mode of operation: check prints metrics and exits, serve runs an exporter serving /metrics, events also sends an event per backend to the agent",
+			Value:    &config.Mode,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "listen-address",
+			Env:      "HAPROXY_LISTEN_ADDRESS",
+			Argument: "listen-address",
+			Default:  ":9101",
+			Usage:    "address to serve /metrics on in serve mode",
+			Value:    &config.ListenAddress,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "cache-seconds",
+			Env:      "HAPROXY_CACHE_SECONDS",
+			Argument: "cache-seconds",
+			Default:  5,
+			Usage:    "seconds to reuse a scrape for in serve mode, 0 to scrape on every request",
+			Value:    &config.CacheSeconds,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "events-url",
+			Env:      "HAPROXY_EVENTS_URL",
+			Argument: "events-url",
+			Default:  "http://127.0.0.1:3031/events",
+			Usage:    "agent events API URL to send events to in events mode",
+			Value:    &config.EventsURL,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "server-events",
+			Env:      "HAPROXY_SERVER_EVENTS",
+			Argument: "server-events",
+			Usage:    "in events mode, also send an event per backend server",
+			Value:    &config.ServerEvents,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "server-entities",
+			Env:      "HAPROXY_SERVER_ENTITIES",
+			Argument: "server-entities",
+			Usage:    "in events mode, send the server events on a proxy entity per server, implies --server-events",
+			Value:    &config.ServerEntities,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "entity-prefix",
+			Env:      "HAPROXY_ENTITY_PREFIX",
+			Argument: "entity-prefix",
+			Default:  "",
+			Usage:    "prefix for the names of the proxy entities created in events mode",
+			Value:    &config.EntityPrefix,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "available-warning",
+			Env:      "HAPROXY_AVAILABLE_WARNING",
+			Argument: "available-warning",
+			Default:  float64(100),
+			Usage:    "warn when less than this percentage of a backend's servers is available, in check mode only with --alert",
+			Value:    &config.AvailableWarning,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "available-critical",
+			Env:      "HAPROXY_AVAILABLE_CRITICAL",
+			Argument: "available-critical",
+			Default:  float64(50),
+			Usage:    "critical when less than this percentage of a backend's servers is available, in check mode only with --alert",
+			Value:    &config.AvailableCritical,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "max-maintenance-seconds",
+			Env:      "HAPROXY_MAX_MAINTENANCE_SECONDS",
+			Argument: "max-maintenance-seconds",
+			Default:  0,
+			Usage:    "warn when a server has been in MAINT, DRAIN or NOLB for longer than this, 0 to disable, in check mode only with --alert",
+			Value:    &config.MaxMaintenanceSeconds,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "state-file",
+			Env:      "HAPROXY_STATE_FILE",
+			Argument: "state-file",
+			Default:  "",
+			Usage:    "file to keep counters in between runs, required for the rules that compare runs, such as flapping detection",
+			Value:    &config.StateFile,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "flap-warning",
+			Env:      "HAPROXY_FLAP_WARNING",
+			Argument: "flap-warning",
+			Default:  2,
+			Usage:    "warn when a server went DOWN this many times per --flap-interval since the previous run, 0 to disable, in check mode only with --alert",
+			Value:    &config.FlapWarning,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "flap-critical",
+			Env:      "HAPROXY_FLAP_CRITICAL",
+			Argument: "flap-critical",
+			Default:  5,
+			Usage:    "critical when a server went DOWN this many times per --flap-interval since the previous run, 0 to disable, in check mode only with --alert",
+			Value:    &config.FlapCritical,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "flap-interval",
+			Env:      "HAPROXY_FLAP_INTERVAL",
+			Argument: "flap-interval",
+			Default:  600,
+			Usage:    "seconds to count flapping transitions over, transitions since an older previous run are averaged over this interval",
+			Value:    &config.FlapInterval,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "latency-warning",
+			Env:      "HAPROXY_LATENCY_WARNING",
+			Argument: "latency-warning",
+			Default:  []string{},
+			Usage:    "warn when an average time of a backend or server is above these seconds, as [proxy[/server]:]{qtime,ctime,rtime,ttime}=seconds, in check mode only with --alert",
+			Value:    &config.LatencyWarning,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "latency-critical",
+			Env:      "HAPROXY_LATENCY_CRITICAL",
+			Argument: "latency-critical",
+			Default:  []string{},
+			Usage:    "critical when an average time of a backend or server is above these seconds, as [proxy[/server]:]{qtime,ctime,rtime,ttime}=seconds, in check mode only with --alert",
+			Value:    &config.LatencyCritical,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "latency-ceiling",
+			Env:      "HAPROXY_LATENCY_CEILING",
+			Argument: "latency-ceiling",
+			Default:  []string{},
+			Usage:    "critical when a max time of a backend or server is above these seconds, as [proxy[/server]:]{qtime,ctime,rtime,ttime}=seconds, in check mode only with --alert",
+			Value:    &config.LatencyCeiling,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "agent-critical",
+			Env:      "HAPROXY_AGENT_CRITICAL",
+			Argument: "agent-critical",
+			Usage:    "treat failing agent checks as critical instead of warning, in check mode only with --alert",
+			Value:    &config.AgentCritical,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "min-weight-percent",
+			Env:      "HAPROXY_MIN_WEIGHT_PERCENT",
+			Argument: "min-weight-percent",
+			Default:  float64(50),
+			Usage:    "warn when a server that is UP runs with an effective weight below this percentage of its user weight, in check mode only with --alert",
+			Value:    &config.MinWeightPercent,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "expected-weight",
+			Env:      "HAPROXY_EXPECTED_WEIGHT",
+			Argument: "expected-weight",
+			Default:  []string{},
+			Usage:    "warn when the user weight of a server differs from this, as [proxy[/server]:]weight=N, in check mode only with --alert",
+			Value:    &config.ExpectedWeight,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "idle-pool-warning",
+			Env:      "HAPROXY_IDLE_POOL_WARNING",
+			Argument: "idle-pool-warning",
+			Default:  float64(0),
+			Usage:    "warn when the idle connection pool of a server is at least this percentage full (0 to disable), in check mode only with --alert",
+			Value:    &config.IdlePoolWarning,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "cache-hit-warning",
+			Env:      "HAPROXY_CACHE_HIT_WARNING",
+			Argument: "cache-hit-warning",
+			Default:  []string{},
+			Usage:    "warn when the cache hit ratio of a frontend or backend is below this, as [proxy:]hit_ratio=N, in check mode only with --alert",
+			Value:    &config.CacheHitWarning,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "ssl-handshake-warning",
+			Env:      "HAPROXY_SSL_HANDSHAKE_WARNING",
+			Argument: "ssl-handshake-warning",
+			Default:  []string{},
+			Usage:    "warn when the SSL handshakes that failed on a frontend since the previous run exceed this, as [proxy:]failures=N or [proxy:]failure_ratio=N, in check mode only with --alert",
+			Value:    &config.SSLHandshakeWarning,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "ssl-handshake-critical",
+			Env:      "HAPROXY_SSL_HANDSHAKE_CRITICAL",
+			Argument: "ssl-handshake-critical",
+			Default:  []string{},
+			Usage:    "critical when the SSL handshakes that failed on a frontend since the previous run exceed this, as [proxy:]failures=N or [proxy:]failure_ratio=N, in check mode only with --alert",
+			Value:    &config.SSLHandshakeCritical,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "protocol-error-warning",
+			Env:      "HAPROXY_PROTOCOL_ERROR_WARNING",
+			Argument: "protocol-error-warning",
+			Default:  []string{},
+			Usage:    "warn when the protocol errors a frontend or backend detected since the previous run exceed this, as [proxy:]column=N, in check mode only with --alert",
+			Value:    &config.ProtocolErrorWarning,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "protocol-error-critical",
+			Env:      "HAPROXY_PROTOCOL_ERROR_CRITICAL",
+			Argument: "protocol-error-critical",
+			Default:  []string{},
+			Usage:    "critical when the protocol errors a frontend or backend detected since the previous run exceed this, as [proxy:]column=N, in check mode only with --alert",
+			Value:    &config.ProtocolErrorCritical,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "ssl-certs",
+			Env:      "HAPROXY_SSL_CERTS",
+			Argument: "ssl-certs",
+			Default:  false,
+			Usage:    "check the expiry of the certificates loaded in HAProxy, with show ssl cert on admin sockets",
+			Value:    &config.SSLCerts,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "cert-warning-days",
+			Env:      "HAPROXY_CERT_WARNING_DAYS",
+			Argument: "cert-warning-days",
+			Default:  30,
+			Usage:    "warn when a certificate expires in fewer than this many days, in check mode only with --alert",
+			Value:    &config.CertWarningDays,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "cert-critical-days",
+			Env:      "HAPROXY_CERT_CRITICAL_DAYS",
+			Argument: "cert-critical-days",
+			Default:  7,
+			Usage:    "critical when a certificate expires in fewer than this many days, in check mode only with --alert",
+			Value:    &config.CertCriticalDays,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "ocsp",
+			Env:      "HAPROXY_OCSP",
+			Argument: "ocsp",
+			Default:  false,
+			Usage:    "check the OCSP responses stapled by HAProxy, with show ssl ocsp-response on admin sockets",
+			Value:    &config.OCSP,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "ocsp-certs",
+			Env:      "HAPROXY_OCSP_CERTS",
+			Argument: "ocsp-certs",
+			Default:  []string{},
+			Usage:    "glob patterns of the certificate files that must have an OCSP response (default all)",
+			Value:    &config.OCSPCerts,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "ocsp-warning-hours",
+			Env:      "HAPROXY_OCSP_WARNING_HOURS",
+			Argument: "ocsp-warning-hours",
+			Default:  24,
+			Usage:    "warn when an OCSP response expires in fewer than this many hours, in check mode only with --alert",
+			Value:    &config.OCSPWarningHours,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "stick-tables",
+			Env:      "HAPROXY_STICK_TABLES",
+			Argument: "stick-tables",
+			Default:  false,
+			Usage:    "monitor the stick tables, with show table on admin sockets",
+			Value:    &config.StickTables,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "table-warning",
+			Env:      "HAPROXY_TABLE_WARNING",
+			Argument: "table-warning",
+			Default:  float64(80),
+			Usage:    "warn when a stick table is at least this percentage full (0 to disable), in check mode only with --alert",
+			Value:    &config.TableWarning,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "table-critical",
+			Env:      "HAPROXY_TABLE_CRITICAL",
+			Argument: "table-critical",
+			Default:  float64(95),
+			Usage:    "critical when a stick table is at least this percentage full (0 to disable), in check mode only with --alert",
+			Value:    &config.TableCritical,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "table-top",
+			Env:      "HAPROXY_TABLE_TOP",
+			Argument: "table-top",
+			Default:  0,
+			Usage:    "export the entries of every stick table with the highest --table-top-counter, up to this many",
+			Value:    &config.TableTop,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "table-top-counter",
+			Env:      "HAPROXY_TABLE_TOP_COUNTER",
+			Argument: "table-top-counter",
+			Default:  "http_req_rate",
+			Usage:    "the stored counter that --table-top orders stick table entries by, such as http_req_rate or conn_cur",
+			Value:    &config.TableTopCounter,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "resolvers",
+			Env:      "HAPROXY_RESOLVERS",
+			Argument: "resolvers",
+			Default:  false,
+			Usage:    "monitor the nameservers of the resolvers sections, with show resolvers on admin sockets",
+			Value:    &config.Resolvers,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "resolver-warning",
+			Env:      "HAPROXY_RESOLVER_WARNING",
+			Argument: "resolver-warning",
+			Default:  []string{},
+			Usage:    "warn when the error or timeout rate of a nameserver exceeds this, as [resolvers[/nameserver]:]error_rate=N or timeout_rate=N, in check mode only with --alert",
+			Value:    &config.ResolverWarning,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "resolver-critical",
+			Env:      "HAPROXY_RESOLVER_CRITICAL",
+			Argument: "resolver-critical",
+			Default:  []string{},
+			Usage:    "critical when the error or timeout rate of a nameserver exceeds this, as [resolvers[/nameserver]:]error_rate=N or timeout_rate=N, in check mode only with --alert",
+			Value:    &config.ResolverCritical,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "peers",
+			Env:      "HAPROXY_PEERS",
+			Argument: "peers",
+			Default:  false,
+			Usage:    "monitor the peers sections, with show peers on admin sockets, critical about disconnected peers in check mode only with --alert",
+			Value:    &config.Peers,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "show-errors",
+			Env:      "HAPROXY_SHOW_ERRORS",
+			Argument: "show-errors",
+			Default:  false,
+			Usage:    "list the invalid requests and responses captured since the previous run, with show errors on admin sockets",
+			Value:    &config.ShowErrors,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "sessions",
+			Env:      "HAPROXY_SESSIONS",
+			Argument: "sessions",
+			Default:  false,
+			Usage:    "count the live sessions, with show sess on admin sockets",
+			Value:    &config.Sessions,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "session-age",
+			Env:      "HAPROXY_SESSION_AGE",
+			Argument: "session-age",
+			Default:  3600,
+			Usage:    "the age in seconds above which sessions are counted as long-lived",
+			Value:    &config.SessionAge,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "old-sessions-warning",
+			Env:      "HAPROXY_OLD_SESSIONS_WARNING",
+			Argument: "old-sessions-warning",
+			Default:  0,
+			Usage:    "warn when a backend has at least this many sessions older than --session-age (0 to disable), in check mode only with --alert",
+			Value:    &config.OldSessionsWarning,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "old-sessions-critical",
+			Env:      "HAPROXY_OLD_SESSIONS_CRITICAL",
+			Argument: "old-sessions-critical",
+			Default:  0,
+			Usage:    "critical when a backend has at least this many sessions older than --session-age (0 to disable), in check mode only with --alert",
+			Value:    &config.OldSessionsCritical,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "pools",
+			Env:      "HAPROXY_POOLS",
+			Argument: "pools",
+			Default:  false,
+			Usage:    "monitor the memory pools, with show pools on admin sockets",
+			Value:    &config.Pools,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "activity",
+			Env:      "HAPROXY_ACTIVITY",
+			Argument: "activity",
+			Default:  false,
+			Usage:    "export the activity counters, with show activity on admin sockets",
+			Value:    &config.Activity,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "pool-failure-warning",
+			Env:      "HAPROXY_POOL_FAILURE_WARNING",
+			Argument: "pool-failure-warning",
+			Default:  1,
+			Usage:    "warn when a memory pool failed at least this many allocations since the previous run, with --state-file (0 to disable), in check mode only with --alert",
+			Value:    &config.PoolFailureWarning,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "pool-failure-critical",
+			Env:      "HAPROXY_POOL_FAILURE_CRITICAL",
+			Argument: "pool-failure-critical",
+			Default:  0,
+			Usage:    "critical when a memory pool failed at least this many allocations since the previous run, with --state-file (0 to disable), in check mode only with --alert",
+			Value:    &config.PoolFailureCritical,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "output-template",
+			Env:      "HAPROXY_OUTPUT_TEMPLATE",
+			Argument: "output-template",
+			Default:  "",
+			Usage:    "text/template for the summary printed before the metrics in check mode, defaults to a list of unhealthy frontends, backends and servers",
+			Value:    &config.OutputTemplate,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "alert",
+			Env:      "HAPROXY_ALERT",
+			Argument: "alert",
+			Usage:    "in check mode, return the worst status of the rules instead of OK",
+			Value:    &config.Alert,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "include-proxy",
+			Env:      "HAPROXY_INCLUDE_PROXY",
+			Argument: "include-proxy",
+			Default:  []string{},
+			Usage:    "only monitor proxies (pxname) matching these globs or /regular expressions/",
+			Value:    &config.IncludeProxy,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "include-server",
+			Env:      "HAPROXY_INCLUDE_SERVER",
+			Argument: "include-server",
+			Default:  []string{},
+			Usage:    "only monitor servers (svname) matching these globs or /regular expressions/",
+			Value:    &config.IncludeServer,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "include-type",
+			Env:      "HAPROXY_INCLUDE_TYPE",
+			Argument: "include-type",
+			Default:  []string{},
+			Usage:    "only monitor types (frontend, backend, server, listener) matching these globs or /regular expressions/",
+			Value:    &config.IncludeType,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "exclude-proxy",
+			Env:      "HAPROXY_EXCLUDE_PROXY",
+			Argument: "exclude-proxy",
+			Default:  []string{},
+			Usage:    "do not monitor proxies (pxname) matching these globs or /regular expressions/",
+			Value:    &config.ExcludeProxy,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "exclude-server",
+			Env:      "HAPROXY_EXCLUDE_SERVER",
+			Argument: "exclude-server",
+			Default:  []string{},
+			Usage:    "do not monitor servers (svname) matching these globs or /regular expressions/",
+			Value:    &config.ExcludeServer,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "exclude-type",
+			Env:      "HAPROXY_EXCLUDE_TYPE",
+			Argument: "exclude-type",
+			Default:  []string{},
+			Usage:    "do not monitor types (frontend, backend, server, listener) matching these globs or /regular expressions/",
+			Value:    &config.ExcludeType,
+		},
 	}
 )
 
@@ -129,6 +651,60 @@ func checkArgs(event *corev2.Event) (int, error) {
 		default:
 			return sensu.CheckStateWarning, fmt.Errorf("unsupported protocol scheme: %s", u.Scheme)
 		}
+		if _, err := newFilter(config, u); err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("invalid filter for %s: %s", cfgURL, err)
+		}
+	}
+	switch config.Mode {
+	case "check", "serve", "events":
+	default:
+		return sensu.CheckStateWarning, fmt.Errorf("unsupported mode: %s", config.Mode)
+	}
+	if config.CacheSeconds < 0 {
+		return sensu.CheckStateWarning, errors.New("--cache-seconds must not be negative")
+	}
+	if config.FlapInterval <= 0 {
+		return sensu.CheckStateWarning, errors.New("--flap-interval must be positive")
+	}
+	for _, specs := range [][]string{config.LatencyWarning, config.LatencyCritical, config.LatencyCeiling} {
+		if _, err := parseThresholds(specs, latencyColumns...); err != nil {
+			return sensu.CheckStateWarning, err
+		}
+	}
+	if _, err := parseThresholds(config.ExpectedWeight, "weight"); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	if _, err := parseThresholds(config.CacheHitWarning, "hit_ratio"); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	for _, specs := range [][]string{config.SSLHandshakeWarning, config.SSLHandshakeCritical} {
+		if _, err := parseThresholds(specs, sslHandshakeNames...); err != nil {
+			return sensu.CheckStateWarning, err
+		}
+	}
+	for _, specs := range [][]string{config.ProtocolErrorWarning, config.ProtocolErrorCritical} {
+		if _, err := parseThresholds(specs, protocolErrorColumns...); err != nil {
+			return sensu.CheckStateWarning, err
+		}
+	}
+	for _, specs := range [][]string{config.ResolverWarning, config.ResolverCritical} {
+		if _, err := parseThresholds(specs, resolverRateNames...); err != nil {
+			return sensu.CheckStateWarning, err
+		}
+	}
+	if config.TableTop < 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--table-top must not be negative")
+	}
+	if !tableCounterRE.MatchString(config.TableTopCounter) {
+		return sensu.CheckStateWarning, fmt.Errorf("invalid stick table counter: %s", config.TableTopCounter)
+	}
+	for _, pattern := range config.OCSPCerts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("invalid OCSP certificate pattern %q: %s", pattern, err)
+		}
+	}
+	if _, err := outputTemplate(config); err != nil {
+		return sensu.CheckStateWarning, fmt.Errorf("invalid output template: %s", err)
 	}
 	if err := checkTLS(config); err != nil {
 		return sensu.CheckStateWarning, fmt.Errorf("invalid TLS configuration: %s", err)
@@ -163,34 +739,123 @@ func checkTLS(config Config) error {
 	return nil
 }
 
+// outputTemplate parses the configured output template, or the default
+// template if none is configured.
+func outputTemplate(config Config) (*template.Template, error) {
+	if config.OutputTemplate == "" {
+		return parseOutputTemplate(defaultOutputTemplate)
+	}
+	return parseOutputTemplate(config.OutputTemplate)
+}
+
 func executeCheck(event *corev2.Event) (int, error) {
+	if config.Mode == "serve" {
+		return sensu.CheckStateWarning, serve(config)
+	}
+	state := &stateFile{Targets: make(map[string]*snapshot)}
+	if config.StateFile != "" {
+		var err error
+		state, err = loadState(config.StateFile)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("error reading state: %s", err)
+		}
+	}
+	status := sensu.CheckStateOK
 	for _, cfgURL := range config.URLs {
 		url, err := url.Parse(cfgURL)
 		if err != nil {
 			// shouldn't happen as inputs are validated elsewhere
 			return sensu.CheckStateWarning, err
 		}
-		if url.Scheme == "" || url.Scheme == "unix" || url.Scheme == "file" {
-			data, err := readUnix(url)
-			if err != nil {
-				return sensu.CheckStateWarning, err
-			}
-			if err := outputMetrics(data); err != nil {
-				return sensu.CheckStateWarning, err
-			}
-		} else if url.Scheme == "http" || url.Scheme == "https" {
-			data, err := readHTTP(url, config)
-			if err != nil {
-				return sensu.CheckStateWarning, err
-			}
-			if err := outputMetrics(data); err != nil {
-				return sensu.CheckStateWarning, err
-			}
-		} else {
-			return sensu.CheckStateWarning, fmt.Errorf("unsupported protocol scheme: %s", err)
+		urlStatus, err := checkURL(os.Stdout, url, state)
+		if err != nil {
+			return sensu.CheckStateWarning, err
+		}
+		if severity(urlStatus) > severity(status) {
+			status = urlStatus
 		}
 	}
-	return sensu.CheckStateOK, nil
+	if config.StateFile != "" {
+		if err := state.save(config.StateFile); err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("error writing state: %s", err)
+		}
+	}
+	return status, nil
+}
+
+// checkURL scrapes url and writes its metrics to w. In check mode, the
+// metrics are preceded by a summary of the evaluated rules, and with
+// config.Alert the worst status of the rules is returned. In events mode, the
+// results are sent to the agent as events instead. The rules are evaluated
+// against the previous snapshot of url in state, which is then replaced with
+// the current one.
+func checkURL(w io.Writer, url *url.URL, state *stateFile) (int, error) {
+	f, err := newFilter(config, url)
+	if err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	data, err := readStats(url, config)
+	if err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	db, err := createDB(data, f)
+	if err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	defer db.Close()
+	rows, err := loadRows(db)
+	if err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	socket, err := readSocketData(url, config)
+	if err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	socket.applyFilter(f)
+	eval := &evaluation{
+		rows:     rows,
+		previous: state.Targets[url.String()],
+		now:      time.Now(),
+		socket:   socket,
+	}
+	current := newSnapshot(rows, eval.now)
+	if socket != nil {
+		current.Socket = socket.counters()
+	}
+	state.Targets[url.String()] = current
+	if config.Mode == "events" {
+		if err := outputMetrics(w, db, socket, eval.previous); err != nil {
+			return sensu.CheckStateWarning, err
+		}
+		return sensu.CheckStateOK, sendEvents(eval, url)
+	}
+	tmpl, err := outputTemplate(config)
+	if err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	var info map[string]string
+	if socket != nil {
+		info = socket.info
+	}
+	results := evaluate(eval)
+	output := &outputData{
+		URL:     stripFilterParams(url).Redacted(),
+		Status:  worst(results),
+		Rows:    rows,
+		Results: results,
+		Info:    info,
+		Errors:  eval.newErrors(),
+	}
+	if err := writeOutput(w, tmpl, output); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	if err := outputMetrics(w, db, socket, eval.previous); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	if !config.Alert {
+		return sensu.CheckStateOK, nil
+	}
+	return output.Status, nil
 }
 
 type statsData struct {
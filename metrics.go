@@ -4,30 +4,28 @@ import (
 	"database/sql"
 	"fmt"
 	"io"
-	"os"
-
-	"github.com/prometheus/client_golang/prometheus"
 )
 
-func init() {
-	for _, v := range prometheusMetrics {
-		prometheus.MustRegister(v)
-	}
-	// unregister the default collectors. We could also create a new registry,
-	// but this was actually easier to figure out how to do.
-	prometheus.Unregister(prometheus.NewGoCollector())
-	prometheus.Unregister(prometheus.NewBuildInfoCollector())
-	prometheus.Unregister(prometheus.NewExpvarCollector(nil))
-	prometheus.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
-}
-
 var metrics = []string{
 	"active_servers",
+	"agent_code",
+	"agent_duration",
+	"agent_fall",
+	"agent_health",
+	"agent_rise",
 	"backup_servers",
 	"bin",
 	"bout",
+	"cache_hits",
+	"cache_lookups",
 	"chkfail",
+	"comp_byp",
+	"comp_in",
+	"comp_out",
+	"comp_rsp",
+	"connect",
 	"ctime",
+	"ctime_max",
 	"dreq",
 	"dresp",
 	"econ",
@@ -39,15 +37,29 @@ var metrics = []string{
 	"http_response_4xx",
 	"http_response_5xx",
 	"http_response_other",
+	"idle_conn_cur",
+	"need_conn_est",
 	"qcur",
 	"qmax",
 	"qtime",
+	"qtime_max",
 	"rate",
+	"reuse",
 	"rtime",
+	"rtime_max",
+	"safe_conn_cur",
 	"scur",
 	"slim",
 	"smax",
+	"src_ilim",
+	"srv_icur",
+	"ssl_failed_handshake",
+	"ssl_reused_sess",
+	"ssl_sess",
 	"ttime",
+	"ttime_max",
+	"used_conn_cur",
+	"uweight",
 	"weight",
 	"wredis",
 	"wretr",
@@ -91,208 +103,107 @@ var reverseNameLookup = map[string]string{
 }
 
 var helpLookup = map[string]string{
-	"pxname":         "proxy name",
-	"svname":         "service name",
-	"qcur":           "current queued requests",
-	"qmax":           "max queued requests",
-	"scur":           "session current",
-	"smax":           "session max",
-	"slim":           "session limit",
-	"stot":           "session total",
-	"bin":            "bytes in",
-	"bout":           "bytes out",
-	"dreq":           "request denied security",
-	"dresp":          "response denied security",
-	"ereq":           "request errors",
-	"econ":           "connection errors",
-	"eresp":          "response errors",
-	"wretr":          "warning retries",
-	"wredis":         "warning redispatched",
-	"status":         "status",
-	"weight":         "weight",
-	"act":            "servers active",
-	"bck":            "servers backup",
-	"chkfail":        "healthcheck failed",
-	"chkdown":        "healthcheck transitions",
-	"lastchg":        "healthcheck seconds since change",
-	"downtime":       "healthcheck downtime",
-	"qlimit":         "server queue limit",
-	"pid":            "process id",
-	"iid":            "proxy id",
-	"sid":            "server id",
-	"throttle":       "server throttle percent",
-	"lbtot":          "server selected",
-	"tracked":        "tracked server id",
-	"type":           "type",
-	"rate":           "session rate",
-	"rate_lim":       "session rate limit",
-	"rate_max":       "session rate max",
-	"check_status":   "check status",
-	"check_code":     "check code",
-	"check_duration": "healthcheck duration",
-	"hrsp_1xx":       "response status 1xx",
-	"hrsp_2xx":       "response status 2xx",
-	"hrsp_3xx":       "response status 3xx",
-	"hrsp_4xx":       "response status 4xx",
-	"hrsp_5xx":       "response status 5xx",
-	"hrsp_other":     "response status other",
-	"hanafail":       "failed healthcheck details",
-	"req_rate":       "requests per second",
-	"req_rate_max":   "requests per second max",
-	"req_tot":        "total requests",
-	"cli_abrt":       "client transfer aborts",
-	"srv_abrt":       "server transfer aborts",
-	"comp_in":        "compressor in",
-	"comp_out":       "compressor out",
-	"comp_byp":       "compressor bytes",
-	"comp_rsp":       "compressor responses",
-	"lastsess":       "session last assigned seconds",
-	"last_chk":       "healthcheck contents",
-	"last_agt":       "agent check contents",
-	"qtime":          "queue time",
-	"ctime":          "connect time",
-	"rtime":          "response time",
-	"ttime":          "average time",
-	"agent_status":   "agent status",
-	"agent_code":     "agent code",
-	"agent_duration": "agent duration",
-	"check_desc":     "check description",
-	"agent_desc":     "agent description",
-	"check_rise":     "check rise",
-	"check_fall":     "check fall",
-	"check_health":   "check health",
-	"agent_rise":     "agent rise",
-	"agent_fall":     "agent fall",
-	"agent_health":   "agent health",
-	"addr":           "address",
-	"cookie":         "cookie",
-	"mode":           "mode",
-	"algo":           "algorithm",
-	"conn_rate":      "connection rate",
-	"conn_rate_max":  "connection rate max",
-	"conn_tot":       "connection tot",
-	"intercepted":    "requests intercepted",
-	"dcon":           "connection requests denied",
-	"dses":           "session requests denied",
-}
-
-var prometheusMetrics = map[string]*prometheus.GaugeVec{
-	"active_servers": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_active_servers",
-		Help: lookupHelp("active_servers"),
-	}, tags),
-	"backup_servers": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_backup_servers",
-		Help: lookupHelp("backup_servers"),
-	}, tags),
-	"bin": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_bin",
-		Help: lookupHelp("bin"),
-	}, tags),
-	"bout": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_bout",
-		Help: lookupHelp("bout"),
-	}, tags),
-	"chkfail": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_chkfail",
-		Help: lookupHelp("chkfail"),
-	}, tags),
-	"ctime": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_ctime",
-		Help: lookupHelp("ctime"),
-	}, tags),
-	"dreq": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_dreq",
-		Help: lookupHelp("dreq"),
-	}, tags),
-	"dresp": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_dresp",
-		Help: lookupHelp("dresp"),
-	}, tags),
-	"econ": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_econ",
-		Help: lookupHelp("econ"),
-	}, tags),
-	"ereq": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_ereq",
-		Help: lookupHelp("ereq"),
-	}, tags),
-	"eresp": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_eresp",
-		Help: lookupHelp("eresp"),
-	}, tags),
-	"http_response_1xx": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_http_response_1xx",
-		Help: lookupHelp("http_response_1xx"),
-	}, tags),
-	"http_response_2xx": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_http_response_2xx",
-		Help: lookupHelp("http_response_2xx"),
-	}, tags),
-	"http_response_3xx": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_http_response_3xx",
-		Help: lookupHelp("http_response_3xx"),
-	}, tags),
-	"http_response_4xx": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_http_response_4xx",
-		Help: lookupHelp("http_response_4xx"),
-	}, tags),
-	"http_response_5xx": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_http_response_5xx",
-		Help: lookupHelp("http_response_5xx"),
-	}, tags),
-	"http_response_other": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_http_response_other",
-		Help: lookupHelp("http_response_other"),
-	}, tags),
-	"qcur": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_qcur",
-		Help: lookupHelp("qcur"),
-	}, tags),
-	"qmax": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_qmax",
-		Help: lookupHelp("qmax"),
-	}, tags),
-	"qtime": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_qtime",
-		Help: lookupHelp("qtime"),
-	}, tags),
-	"rate": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_rate",
-		Help: lookupHelp("rate"),
-	}, tags),
-	"rtime": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_rtime",
-		Help: lookupHelp("rtime"),
-	}, tags),
-	"scur": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_scur",
-		Help: lookupHelp("scur"),
-	}, tags),
-	"slim": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_slim",
-		Help: lookupHelp("slim"),
-	}, tags),
-	"smax": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_smax",
-		Help: lookupHelp("smax"),
-	}, tags),
-	"ttime": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_ttime",
-		Help: lookupHelp("ttime"),
-	}, tags),
-	"weight": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_weight",
-		Help: lookupHelp("weight"),
-	}, tags),
-	"wredis": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_wredis",
-		Help: lookupHelp("wredis"),
-	}, tags),
-	"wretr": prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "haproxy_wretr",
-		Help: lookupHelp("wretr"),
-	}, tags),
+	"pxname":               "proxy name",
+	"svname":               "service name",
+	"qcur":                 "current queued requests",
+	"qmax":                 "max queued requests",
+	"scur":                 "session current",
+	"smax":                 "session max",
+	"slim":                 "session limit",
+	"stot":                 "session total",
+	"bin":                  "bytes in",
+	"bout":                 "bytes out",
+	"dreq":                 "request denied security",
+	"dresp":                "response denied security",
+	"ereq":                 "request errors",
+	"econ":                 "connection errors",
+	"eresp":                "response errors",
+	"wretr":                "warning retries",
+	"wredis":               "warning redispatched",
+	"status":               "status",
+	"weight":               "weight",
+	"uweight":              "user weight",
+	"act":                  "servers active",
+	"bck":                  "servers backup",
+	"chkfail":              "healthcheck failed",
+	"chkdown":              "healthcheck transitions",
+	"lastchg":              "healthcheck seconds since change",
+	"downtime":             "healthcheck downtime",
+	"qlimit":               "server queue limit",
+	"pid":                  "process id",
+	"iid":                  "proxy id",
+	"sid":                  "server id",
+	"throttle":             "server throttle percent",
+	"lbtot":                "server selected",
+	"tracked":              "tracked server id",
+	"type":                 "type",
+	"rate":                 "session rate",
+	"rate_lim":             "session rate limit",
+	"rate_max":             "session rate max",
+	"check_status":         "check status",
+	"check_code":           "check code",
+	"check_duration":       "healthcheck duration",
+	"hrsp_1xx":             "response status 1xx",
+	"hrsp_2xx":             "response status 2xx",
+	"hrsp_3xx":             "response status 3xx",
+	"hrsp_4xx":             "response status 4xx",
+	"hrsp_5xx":             "response status 5xx",
+	"hrsp_other":           "response status other",
+	"hanafail":             "failed healthcheck details",
+	"req_rate":             "requests per second",
+	"req_rate_max":         "requests per second max",
+	"req_tot":              "total requests",
+	"cli_abrt":             "client transfer aborts",
+	"srv_abrt":             "server transfer aborts",
+	"comp_in":              "compressor in",
+	"comp_out":             "compressor out",
+	"comp_byp":             "compressor bypassed bytes",
+	"comp_rsp":             "compressor responses",
+	"lastsess":             "session last assigned seconds",
+	"last_chk":             "healthcheck contents",
+	"last_agt":             "agent check contents",
+	"qtime":                "queue time",
+	"ctime":                "connect time",
+	"rtime":                "response time",
+	"ttime":                "average time",
+	"qtime_max":            "queue time max",
+	"ctime_max":            "connect time max",
+	"rtime_max":            "response time max",
+	"ttime_max":            "total time max",
+	"agent_status":         "agent status",
+	"agent_code":           "agent code",
+	"agent_duration":       "agent duration",
+	"check_desc":           "check description",
+	"agent_desc":           "agent description",
+	"check_rise":           "check rise",
+	"check_fall":           "check fall",
+	"check_health":         "check health",
+	"agent_rise":           "agent rise",
+	"agent_fall":           "agent fall",
+	"agent_health":         "agent health",
+	"addr":                 "address",
+	"cookie":               "cookie",
+	"mode":                 "mode",
+	"algo":                 "algorithm",
+	"conn_rate":            "connection rate",
+	"conn_rate_max":        "connection rate max",
+	"conn_tot":             "connection tot",
+	"intercepted":          "requests intercepted",
+	"dcon":                 "connection requests denied",
+	"dses":                 "session requests denied",
+	"connect":              "connections established",
+	"reuse":                "connections reused",
+	"idle_conn_cur":        "unsafe idle connections",
+	"safe_conn_cur":        "safe idle connections",
+	"used_conn_cur":        "connections in use",
+	"need_conn_est":        "connections estimated needed",
+	"srv_icur":             "idle connections",
+	"src_ilim":             "idle connections limit",
+	"cache_lookups":        "cache lookups",
+	"cache_hits":           "cache hits",
+	"ssl_sess":             "SSL sessions",
+	"ssl_reused_sess":      "SSL sessions reused",
+	"ssl_failed_handshake": "SSL handshakes failed",
 }
 
 var instanceTypes = []string{
@@ -357,8 +268,8 @@ func (r *Row) ScanArgs() []interface{} {
 	}
 }
 
-// SetPrometheus writes the contents of the row to the prometheus gatherer.
-func (r Row) SetPrometheus() {
+// SetPrometheus writes the contents of the row to the exporter.
+func (r Row) SetPrometheus(e *exporter) {
 	if !r.Metric.Valid {
 		return
 	}
@@ -366,10 +277,7 @@ func (r Row) SetPrometheus() {
 	if !ok {
 		name = r.MetricName
 	}
-	gauge, ok := prometheusMetrics[name]
-	if !ok {
-		panic(fmt.Sprintf("can't find metric name: %s", r.MetricName))
-	}
+	gauge := e.gauge("haproxy_"+name, lookupHelp(name), tags...)
 	var hapType string
 	if !r.Type.Valid {
 		hapType = ""
@@ -379,38 +287,76 @@ func (r Row) SetPrometheus() {
 	gauge.WithLabelValues(r.Proxy, r.Host.String, hapType, r.Service).Set(r.Metric.Float64)
 }
 
-// outputMetrics writes all the scraped CSV metrics to prometheus, and then
-// scrapes prometheus to produce the final output.
-func outputMetrics(data *statsData) error {
-	db, err := createDB(data)
+// outputMetrics writes all the scraped CSV metrics and socket metrics to a new
+// exporter, and then encodes the exporter's metrics to w. s and previous may
+// be nil.
+func outputMetrics(w io.Writer, db *sql.DB, s *socketData, previous *snapshot) error {
+	e := newExporter()
+	if err := exportMetrics(e, db, s, previous); err != nil {
+		return err
+	}
+	return e.encode(w)
+}
+
+// exportMetrics writes all the scraped CSV metrics to e, followed by the
+// derived metrics and, if s is not nil, the socket metrics. Derived metrics
+// that compare counters use previous, the snapshot of the previous run, if it
+// is not nil. Metrics for columns that the scraped HAProxy version does not
+// have are skipped.
+func exportMetrics(e *exporter, db *sql.DB, s *socketData, previous *snapshot) error {
+	columns, err := tableColumns(db)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
 	for _, metric := range metrics {
 		metric = lookupName(metric)
+		if !contains(columns, metric) {
+			continue
+		}
 		fmtstr := "%s,'%s'"
 		for range itags {
 			fmtstr = "%s," + fmtstr
 		}
 		cols := fmt.Sprintf(fmtstr, append(itags, []interface{}{metric, metric}...)...)
 		query := fmt.Sprintf("SELECT %s FROM metrics;", cols)
-		err := doQuery(db, query)
+		err := doQuery(db, e, query)
 		if err != nil {
 			return err
 		}
 	}
-	client := netListener.Client()
-	resp, err := client.Get("http://fake/")
+	rows, err := loadRows(db)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	_, err = io.Copy(os.Stdout, resp.Body)
-	return err
+	for _, derive := range derivedMetrics {
+		derive(e, rows, previous)
+	}
+	if s != nil {
+		for _, export := range socketMetrics {
+			export(e, s)
+		}
+	}
+	return nil
+}
+
+// Derived metrics are computed from the stats rows, rather than exported
+// from a single column.
+var derivedMetrics = []func(e *exporter, rows []statRow, previous *snapshot){
+	exportAgentMetrics,
+	exportConnectionMetrics,
+	exportCacheMetrics,
+	exportCompressionMetrics,
+	exportSSLMetrics,
+	exportProtocolMetrics,
+	exportErrorCountMetrics,
+}
+
+// rowLabels returns the values of tags for row.
+func rowLabels(row statRow) []string {
+	return []string{row.Proxy(), row.String("addr"), row.Type(), row.Server()}
 }
 
-func doQuery(db *sql.DB, query string) error {
+func doQuery(db *sql.DB, e *exporter, query string) error {
 	rows, err := db.Query(query)
 	if err != nil {
 		return err
@@ -421,7 +367,7 @@ func doQuery(db *sql.DB, query string) error {
 		if err := rows.Scan(row.ScanArgs()...); err != nil {
 			return err
 		}
-		row.SetPrometheus()
+		row.SetPrometheus(e)
 	}
 	return nil
 }
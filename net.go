@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/tls"
 	"fmt"
@@ -15,7 +16,21 @@ import (
 	"github.com/docker/go-units"
 )
 
+// readStats reads the stats CSV from url, using the socket or HTTP reader
+// depending on its scheme.
+func readStats(url *url.URL, config Config) (*statsData, error) {
+	switch url.Scheme {
+	case "", "unix", "file":
+		return readUnix(url)
+	case "http", "https":
+		return readHTTP(url, config)
+	default:
+		return nil, fmt.Errorf("unsupported protocol scheme: %s", url.Scheme)
+	}
+}
+
 func readHTTP(url *url.URL, config Config) (*statsData, error) {
+	url = stripFilterParams(url)
 	urlString := url.String()
 	if !strings.HasSuffix(urlString, ";csv") && strings.HasSuffix(urlString, "/stats") {
 		// where is the content-type support, haproxy??
@@ -100,13 +115,42 @@ func readHTTP(url *url.URL, config Config) (*statsData, error) {
 	return &statsData{data: buf.Bytes()}, nil
 }
 
+// stripFilterParams returns url without the query parameters that configure
+// its filter, which are not meant for HAProxy.
+func stripFilterParams(url *url.URL) *url.URL {
+	query := url.Query()
+	var stripped bool
+	for name := range query {
+		if isFilterParam(name) {
+			query.Del(name)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return url
+	}
+	result := *url
+	result.RawQuery = query.Encode()
+	return &result
+}
+
 func readUnix(url *url.URL) (*statsData, error) {
+	data, err := runCommand(url, "show stat")
+	if err != nil {
+		return nil, err
+	}
+	return &statsData{data: data}, nil
+}
+
+// runCommand runs a command on the admin socket at url and returns its
+// output.
+func runCommand(url *url.URL, command string) ([]byte, error) {
 	conn, err := net.Dial("unix", url.Path)
 	if err != nil {
 		return nil, fmt.Errorf("error dialing %s: %s", url.String(), err)
 	}
 	defer conn.Close()
-	if _, err := conn.Write([]byte("show stat\n")); err != nil {
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
 		return nil, fmt.Errorf("error querying %s: %s", url.String(), err)
 	}
 	reader := io.LimitReader(conn, units.MB)
@@ -114,5 +158,63 @@ func readUnix(url *url.URL) (*statsData, error) {
 	if _, err := io.Copy(&buf, reader); err != nil {
 		return nil, fmt.Errorf("error reading %s: %s", url.String(), err)
 	}
-	return &statsData{data: buf.Bytes()}, nil
+	return buf.Bytes(), nil
+}
+
+// scanCommand runs a command on the admin socket at url and calls fn with
+// every line of its output. Unlike runCommand, the output is not limited in
+// size, so it is meant for commands whose output grows with the traffic and
+// that are not kept in memory as a whole.
+func scanCommand(url *url.URL, command string, fn func(line string)) error {
+	conn, err := net.Dial("unix", url.Path)
+	if err != nil {
+		return fmt.Errorf("error dialing %s: %s", url.String(), err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return fmt.Errorf("error querying %s: %s", url.String(), err)
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, units.MB)
+	for scanner.Scan() {
+		fn(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %s", url.String(), err)
+	}
+	return nil
+}
+
+// readInfo runs show info on the admin socket at url, and returns its
+// fields by name. Process info is only available from the admin socket, so
+// for other URLs readInfo returns nil.
+func readInfo(url *url.URL) (map[string]string, error) {
+	switch url.Scheme {
+	case "", "unix", "file":
+	default:
+		return nil, nil
+	}
+	data, err := runCommand(url, "show info")
+	if err != nil {
+		return nil, err
+	}
+	return parseFields(data), nil
+}
+
+// parseFields parses the "Key: value" lines that several admin socket
+// commands print. Lines without a colon are skipped, and of repeated keys the
+// first value is kept.
+func parseFields(data []byte) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		if _, ok := fields[key]; !ok {
+			fields[key] = strings.TrimSpace(parts[1])
+		}
+	}
+	return fields
 }
@@ -3,12 +3,19 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/docker/go-units"
 )
 
 func TestReadSocket(t *testing.T) {
@@ -56,3 +63,174 @@ func TestReadSocket(t *testing.T) {
 		t.Error("unexpected data")
 	}
 }
+
+// serveSocket listens on a temporary unix socket, answering every command it
+// receives with responses[command], and returns the socket's URL.
+func serveSocket(t *testing.T, responses map[string]string) *url.URL {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	sock, err := net.Listen("unix", filepath.Join(dir, "admin.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = sock.Close()
+	})
+	go func() {
+		for {
+			conn, err := sock.Accept()
+			if err != nil {
+				return
+			}
+			command, err := bufio.NewReader(conn).ReadString('\n')
+			if err == nil {
+				_, _ = io.WriteString(conn, responses[strings.TrimSpace(command)])
+			}
+			_ = conn.Close()
+		}
+	}()
+	u, err := url.Parse("unix://" + sock.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestReadInfo(t *testing.T) {
+	u := serveSocket(t, map[string]string{
+		"show info": "Name: HAProxy\nVersion: 2.4.0\nPid: 42\nUptime: 0d 1h00m00s\n\n",
+	})
+	info, err := readInfo(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info["Version"], "2.4.0"; got != want {
+		t.Errorf("bad version: got %q, want %q", got, want)
+	}
+	if got, want := info["Uptime"], "0d 1h00m00s"; got != want {
+		t.Errorf("bad uptime: got %q, want %q", got, want)
+	}
+}
+
+func TestReadInfoHTTP(t *testing.T) {
+	u, err := url.Parse("http://localhost/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := readInfo(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info != nil {
+		t.Errorf("expected no info, got %v", info)
+	}
+}
+
+func TestReadSocketDataCerts(t *testing.T) {
+	u := serveSocket(t, map[string]string{
+		"show info":     "Name: HAProxy\nVersion: 2.4.0\n",
+		"show ssl cert": certListOutput,
+		"show ssl cert /etc/haproxy/certs/example.pem": certOutput,
+		"show ssl cert /etc/haproxy/certs/old.pem":     strings.Replace(certOutput, "example.pem", "old.pem", 1),
+	})
+	cfg := defaultConfig()
+	s, err := readSocketData(u, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.certs) != 0 {
+		t.Errorf("certificates read without --ssl-certs: %v", s.certs)
+	}
+	cfg.SSLCerts = true
+	s, err = readSocketData(u, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.certs) != 2 || s.certs[1].File != "/etc/haproxy/certs/old.pem" {
+		t.Errorf("bad certificates: %v", s.certs)
+	}
+}
+
+func TestReadOCSPResponses(t *testing.T) {
+	u := serveSocket(t, map[string]string{
+		"show ssl ocsp-response": ocspListOutput,
+		"show ssl ocsp-response " + parseOCSPList([]byte(ocspListOutput))[0].Key: ocspResponseOutput,
+	})
+	responses, err := readOCSPResponses(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || responses[0].CertStatus != "good" || responses[0].NextUpdate.IsZero() {
+		t.Errorf("bad responses: %v", responses)
+	}
+}
+
+func TestReadTables(t *testing.T) {
+	u := serveSocket(t, map[string]string{
+		"show table": tableListOutput,
+		"show table front_pub data.http_req_rate gt 0": tableEntriesOutput,
+	})
+	cfg := defaultConfig()
+	cfg.TableTop = 1
+	cfg.TableTopCounter = "http_req_rate"
+	tables, err := readTables(u, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 2 || len(tables[0].Top) != 1 || tables[0].Top[0].Key != "10.0.0.2" {
+		t.Errorf("bad tables: %v", tables)
+	}
+}
+
+func TestReadPoolsAndActivity(t *testing.T) {
+	u := serveSocket(t, map[string]string{
+		"show pools":    poolsOutput,
+		"show activity": activityOutput,
+	})
+	pools, err := readPools(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 4 || pools[1].Failures != 7 {
+		t.Errorf("bad pools: %v", pools)
+	}
+	activity, err := readActivity(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activity["tasksw"] != 45 {
+		t.Errorf("bad activity: %v", activity)
+	}
+}
+
+func TestReadTablesLargeTable(t *testing.T) {
+	var entries strings.Builder
+	entries.WriteString("# table: front_pub, type: ip, size:204800, used:30000\n")
+	for i := 0; i < 30000; i++ {
+		fmt.Fprintf(&entries, "0x%012x: key=10.0.%d.%d use=0 exp=49753 http_req_rate(10000)=%d\n", i, i/256, i%256, i)
+	}
+	if entries.Len() <= units.MB {
+		t.Fatalf("output of %d bytes fits in runCommand", entries.Len())
+	}
+	u := serveSocket(t, map[string]string{
+		"show table": "# table: front_pub, type: ip, size:204800, used:30000\n",
+		"show table front_pub data.http_req_rate gt 0": entries.String(),
+	})
+	tables, err := readTables(u, Config{TableTop: 2, TableTopCounter: "http_req_rate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 {
+		t.Fatalf("expected one table, got %v", tables)
+	}
+	top := tables[0].Top
+	if len(top) != 2 || top[0].Key != "10.0.117.47" || top[1].Key != "10.0.117.46" {
+		t.Errorf("bad top entries: %v", top)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// With --ocsp, the OCSP responses that HAProxy staples are read from the admin
+// socket: show ssl ocsp-response lists them by certificate ID, and
+// show ssl ocsp-response <id> prints each response. Responses are matched
+// with the loaded certificates by serial number.
+
+// ocspResponse is an OCSP response loaded in HAProxy.
+type ocspResponse struct {
+	Key        string
+	Serial     string
+	CertStatus string
+	ThisUpdate time.Time
+	NextUpdate time.Time
+}
+
+// readOCSPResponses reads every OCSP response loaded in HAProxy from the admin
+// socket at url.
+func readOCSPResponses(url *url.URL) ([]ocspResponse, error) {
+	data, err := runCommand(url, "show ssl ocsp-response")
+	if err != nil {
+		return nil, err
+	}
+	responses := parseOCSPList(data)
+	for i := range responses {
+		data, err := runCommand(url, "show ssl ocsp-response "+responses[i].Key)
+		if err != nil {
+			return nil, err
+		}
+		if err := parseOCSPResponse(data, &responses[i]); err != nil {
+			return nil, fmt.Errorf("error reading OCSP response %s: %s", responses[i].Key, err)
+		}
+	}
+	return responses, nil
+}
+
+// parseOCSPList parses the output of show ssl ocsp-response, which lists the
+// ID of every response, followed by the serial number of its certificate.
+func parseOCSPList(data []byte) []ocspResponse {
+	var responses []ocspResponse
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch {
+		case key == "Certificate ID key":
+			responses = append(responses, ocspResponse{Key: value})
+		case key == "Serial Number" && len(responses) > 0:
+			responses[len(responses)-1].Serial = value
+		}
+	}
+	return responses
+}
+
+// parseOCSPResponse parses the output of show ssl ocsp-response <id> into r.
+func parseOCSPResponse(data []byte, r *ocspResponse) error {
+	fields := parseFields(data)
+	r.CertStatus = fields["Cert Status"]
+	for name, t := range map[string]*time.Time{"This Update": &r.ThisUpdate, "Next Update": &r.NextUpdate} {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(certTimeLayout, value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %s", name, value, err)
+		}
+		*t = parsed
+	}
+	return nil
+}
+
+// ocspCerts returns the certificates that are expected to have an OCSP
+// response, those whose file matches one of config.OCSPCerts, or all of them
+// if there are no patterns.
+func ocspCerts(certs []certificate) []certificate {
+	if len(config.OCSPCerts) == 0 {
+		return certs
+	}
+	var matched []certificate
+	for _, cert := range certs {
+		for _, pattern := range config.OCSPCerts {
+			if ok, _ := path.Match(pattern, cert.File); ok {
+				matched = append(matched, cert)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// findOCSPResponse returns the OCSP response for cert.
+func findOCSPResponse(responses []ocspResponse, cert certificate) (ocspResponse, bool) {
+	for _, r := range responses {
+		if sameSerial(r.Serial, cert.Serial) {
+			return r, true
+		}
+	}
+	return ocspResponse{}, false
+}
+
+func exportOCSPMetrics(e *exporter, s *socketData) {
+	if !config.OCSP {
+		return
+	}
+	loaded := e.gauge("haproxy_ssl_ocsp_response_loaded", "whether an OCSP response is loaded for the certificate", "file")
+	good := e.gauge("haproxy_ssl_ocsp_cert_status_good", "whether the OCSP response says the certificate is good", "file")
+	thisUpdate := e.gauge("haproxy_ssl_ocsp_this_update_timestamp_seconds", "when the OCSP response was produced", "file")
+	nextUpdate := e.gauge("haproxy_ssl_ocsp_next_update_timestamp_seconds", "when the OCSP response expires", "file")
+	for _, cert := range ocspCerts(s.certs) {
+		r, ok := findOCSPResponse(s.ocsp, cert)
+		if !ok {
+			loaded.WithLabelValues(cert.File).Set(0)
+			continue
+		}
+		loaded.WithLabelValues(cert.File).Set(1)
+		var value float64
+		if r.CertStatus == "good" {
+			value = 1
+		}
+		good.WithLabelValues(cert.File).Set(value)
+		if !r.ThisUpdate.IsZero() {
+			thisUpdate.WithLabelValues(cert.File).Set(float64(r.ThisUpdate.Unix()))
+		}
+		if !r.NextUpdate.IsZero() {
+			nextUpdate.WithLabelValues(cert.File).Set(float64(r.NextUpdate.Unix()))
+		}
+	}
+}
+
+// ocspRule warns about certificates without an OCSP response, or whose
+// response expires within config.OCSPWarningHours, and is critical about
+// certificates whose response has expired or is not good.
+func ocspRule(e *evaluation) []result {
+	if !config.OCSP || e.socket == nil {
+		return nil
+	}
+	warning := time.Duration(config.OCSPWarningHours) * time.Hour
+	var results []result
+	for _, cert := range ocspCerts(e.socket.certs) {
+		r, ok := findOCSPResponse(e.socket.ocsp, cert)
+		switch {
+		case !ok:
+			results = append(results, certResult(cert, sensu.CheckStateWarning, fmt.Sprintf("%s has no OCSP response loaded", cert.name())))
+		case r.CertStatus != "good":
+			results = append(results, certResult(cert, sensu.CheckStateCritical, fmt.Sprintf("%s OCSP response status is %s", cert.name(), r.CertStatus)))
+		case r.NextUpdate.IsZero():
+		case !r.NextUpdate.After(e.now):
+			results = append(results, certResult(cert, sensu.CheckStateCritical, fmt.Sprintf("%s OCSP response expired at %s", cert.name(), r.NextUpdate.Format(time.RFC3339))))
+		case r.NextUpdate.Sub(e.now) < warning:
+			results = append(results, certResult(cert, sensu.CheckStateWarning, fmt.Sprintf("%s OCSP response expires in %s, at %s", cert.name(), r.NextUpdate.Sub(e.now).Round(time.Minute), r.NextUpdate.Format(time.RFC3339))))
+		}
+	}
+	return results
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+const ocspListOutput = `# Certificate IDs
+  Certificate ID key : 303b300906052b0e03021a050004148a83e0060faff709ca7e9b95522a2e81635fda0a0414f652b0e435d5ea923851508f0adbe92d85de007a02100d933c1b1089bf660ae5253a245bb388
+    Certificate ID:
+      Issuer Name Hash: 8A83E0060FAFF709CA7E9B95522A2E81635FDA0A
+      Issuer Key Hash: F652B0E435D5EA923851508F0ADBE92D85DE007A
+      Serial Number: 0D933C1B1089BF660AE5253A245BB388
+`
+
+const ocspResponseOutput = `OCSP Response Data:
+    OCSP Response Status: successful (0x0)
+    Response Type: Basic OCSP Response
+    Version: 1 (0x0)
+    Responder Id: C = US, O = Example CA, CN = Example OCSP Responder
+    Produced At: Oct 18 12:00:00 2026 GMT
+    Responses:
+    Certificate ID:
+      Hash Algorithm: sha1
+      Issuer Name Hash: 8A83E0060FAFF709CA7E9B95522A2E81635FDA0A
+      Issuer Key Hash: F652B0E435D5EA923851508F0ADBE92D85DE007A
+      Serial Number: 0D933C1B1089BF660AE5253A245BB388
+    Cert Status: good
+    This Update: Oct 18 12:00:00 2026 GMT
+    Next Update: Oct 25 12:00:00 2026 GMT
+`
+
+func TestParseOCSP(t *testing.T) {
+	responses := parseOCSPList([]byte(ocspListOutput))
+	if len(responses) != 1 {
+		t.Fatalf("expected one response, got %v", responses)
+	}
+	if got, want := responses[0].Serial, "0D933C1B1089BF660AE5253A245BB388"; got != want {
+		t.Errorf("bad serial: got %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(responses[0].Key, "303b3009") {
+		t.Errorf("bad key: %q", responses[0].Key)
+	}
+	if err := parseOCSPResponse([]byte(ocspResponseOutput), &responses[0]); err != nil {
+		t.Fatal(err)
+	}
+	r := responses[0]
+	if r.CertStatus != "good" {
+		t.Errorf("bad cert status: %q", r.CertStatus)
+	}
+	if want := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC); !r.ThisUpdate.Equal(want) {
+		t.Errorf("bad This Update: got %s, want %s", r.ThisUpdate, want)
+	}
+	if want := time.Date(2026, 10, 25, 12, 0, 0, 0, time.UTC); !r.NextUpdate.Equal(want) {
+		t.Errorf("bad Next Update: got %s, want %s", r.NextUpdate, want)
+	}
+}
+
+func ocspSocket(now time.Time) *socketData {
+	return &socketData{
+		certs: []certificate{
+			{File: "/public/good.pem", Subject: "/CN=good", Serial: "01"},
+			{File: "/public/stale.pem", Subject: "/CN=stale", Serial: "02"},
+			{File: "/public/expiring.pem", Subject: "/CN=expiring", Serial: "03"},
+			{File: "/public/revoked.pem", Subject: "/CN=revoked", Serial: "04"},
+			{File: "/public/missing.pem", Subject: "/CN=missing", Serial: "05"},
+			{File: "/internal/missing.pem", Subject: "/CN=internal", Serial: "06"},
+		},
+		ocsp: []ocspResponse{
+			{Serial: "01", CertStatus: "good", ThisUpdate: now.Add(-time.Hour), NextUpdate: now.AddDate(0, 0, 7)},
+			{Serial: "02", CertStatus: "good", ThisUpdate: now.AddDate(0, 0, -8), NextUpdate: now.Add(-time.Hour)},
+			{Serial: "03", CertStatus: "good", ThisUpdate: now.AddDate(0, 0, -7), NextUpdate: now.Add(2 * time.Hour)},
+			{Serial: "04", CertStatus: "revoked", ThisUpdate: now.Add(-time.Hour), NextUpdate: now.AddDate(0, 0, 7)},
+		},
+	}
+}
+
+func TestOCSPRule(t *testing.T) {
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	cfg := defaultConfig()
+	cfg.OCSP = true
+	cfg.OCSPCerts = []string{"/public/*"}
+	cfg.OCSPWarningHours = 24
+	withConfig(cfg, func() {
+		results := ocspRule(&evaluation{now: now, socket: ocspSocket(now)})
+		want := []struct {
+			status int
+			output string
+		}{
+			{sensu.CheckStateCritical, "certificate /public/stale.pem (/CN=stale) OCSP response expired at 2026-10-18T23:00:00Z"},
+			{sensu.CheckStateWarning, "certificate /public/expiring.pem (/CN=expiring) OCSP response expires in 2h0m0s, at 2026-10-19T02:00:00Z"},
+			{sensu.CheckStateCritical, "certificate /public/revoked.pem (/CN=revoked) OCSP response status is revoked"},
+			{sensu.CheckStateWarning, "certificate /public/missing.pem (/CN=missing) has no OCSP response loaded"},
+		}
+		if len(results) != len(want) {
+			t.Fatalf("bad results: got %v", results)
+		}
+		for i, r := range results {
+			if r.Status != want[i].status || r.Output != want[i].output {
+				t.Errorf("bad result %d:\ngot  %d %q\nwant %d %q", i, r.Status, r.Output, want[i].status, want[i].output)
+			}
+		}
+	})
+	withConfig(defaultConfig(), func() {
+		if results := ocspRule(&evaluation{now: now, socket: ocspSocket(now)}); len(results) != 0 {
+			t.Errorf("expected no results without --ocsp, got %v", results)
+		}
+	})
+}
+
+func TestOCSPMetrics(t *testing.T) {
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	cfg := defaultConfig()
+	cfg.OCSP = true
+	withConfig(cfg, func() {
+		e := newExporter()
+		exportOCSPMetrics(e, ocspSocket(now))
+		wantMetrics(t, exporterText(t, e),
+			`haproxy_ssl_ocsp_response_loaded{file="/public/good.pem"} 1`,
+			`haproxy_ssl_ocsp_response_loaded{file="/internal/missing.pem"} 0`,
+			`haproxy_ssl_ocsp_cert_status_good{file="/public/revoked.pem"} 0`,
+			`haproxy_ssl_ocsp_next_update_timestamp_seconds{file="/public/good.pem"} 1.7929728e+09`,
+		)
+	})
+}
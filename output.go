@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// The check output starts with a human-readable summary of the evaluated
+// rules, rendered with a text/template. Since the output is also parsed as
+// prometheus metrics, every line of the summary is written as a comment.
+
+const defaultOutputTemplate = `{{ status .Status }}: HAProxy {{ .URL }}
+{{- with .Info }} {{ .Version }}, pid {{ .Pid }}, up {{ .Uptime }}{{ end }}
+{{ range .Results }}{{ if .Status }}{{ status .Status }}: {{ .Output }}
+{{ end }}{{ end }}
+{{- range .Rows }}{{ if eq .Type "server" }}{{ if adminState . }}server {{ .Proxy }}/{{ .Server }} is in maintenance: {{ .String "status" }}
+{{ else if not (up .) }}server {{ .Proxy }}/{{ .Server }} is {{ .String "status" }}{{ with checkDetails . }}, {{ . }}{{ end }}
+{{ end }}{{ end }}{{ end }}
+{{- range .Errors }}{{ .Type }} {{ .Proxy }} captured an invalid {{ .Direction }} from {{ .Source }} at {{ .Time }}, error at position {{ .Position }}{{ with .Count }} ({{ . }} errors since the previous run){{ end }}: {{ .Excerpt }}
+{{ end }}`
+
+// outputData is what the output template is executed with.
+type outputData struct {
+	// URL is the URL that was scraped.
+	URL string
+	// Status is the worst status of the results.
+	Status int
+	// Rows are the scraped stats.
+	Rows []statRow
+	// Results are the results of all the rules, ordered by proxy and worst
+	// first.
+	Results []result
+	// Info is the output of show info, or nil if the URL is not an admin
+	// socket.
+	Info map[string]string
+	// Errors are the invalid requests and responses captured since the
+	// previous run, with --show-errors.
+	Errors []capturedError
+}
+
+var outputFuncs = template.FuncMap{
+	"status":              statusName,
+	"up":                  serverUp,
+	"adminState":          adminState,
+	"checkDetails":        checkDetails,
+	"describeCheckStatus": describeCheckStatus,
+}
+
+func parseOutputTemplate(text string) (*template.Template, error) {
+	return template.New("output").Funcs(outputFuncs).Parse(text)
+}
+
+// statusName returns the name of a check status.
+func statusName(status int) string {
+	switch status {
+	case sensu.CheckStateOK:
+		return "OK"
+	case sensu.CheckStateWarning:
+		return "WARNING"
+	case sensu.CheckStateCritical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// writeOutput executes tmpl with data, and writes the result to w as
+// prometheus comments. Empty lines are dropped.
+func writeOutput(w io.Writer, tmpl *template.Template, data *outputData) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" {
+			continue
+		}
+		if _, err := io.WriteString(w, "# "+line+"\n"); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
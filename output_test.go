@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestWriteOutputDefaultTemplate(t *testing.T) {
+	tmpl, err := parseOutputTemplate(defaultOutputTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withConfig(defaultConfig(), func() {
+		rows := testRows(t, statusCSV("UP", "UP", "UP", "DOWN"))
+		results := evaluate(&evaluation{rows: rows})
+		data := &outputData{
+			URL:     "unix:///run/haproxy/admin.sock",
+			Status:  worst(results),
+			Rows:    rows,
+			Results: results,
+			Info:    map[string]string{"Version": "2.4.0", "Pid": "42", "Uptime": "0d 1h00m00s"},
+		}
+		var buf bytes.Buffer
+		if err := writeOutput(&buf, tmpl, data); err != nil {
+			t.Fatal(err)
+		}
+		want := `# CRITICAL: HAProxy unix:///run/haproxy/admin.sock 2.4.0, pid 42, up 0d 1h00m00s
+# WARNING: backend app is UP, 3/4 servers available (75%), unavailable: app4
+# CRITICAL: backend static is DOWN, 0/1 servers available (0%), unavailable: static
+# server static/static is DOWN, check L4CON (layer 1-4 connection problem) in 0ms: Layer4 connection problem, Connection refused
+# server app/app4 is DOWN, check L4CON (layer 1-4 connection problem) in 0ms: Layer4 connection problem, Connection refused
+`
+		if got := buf.String(); got != want {
+			t.Errorf("bad output:\n%s\nwant:\n%s", got, want)
+		}
+	})
+}
+
+func TestWriteOutputCustomTemplate(t *testing.T) {
+	tmpl, err := parseOutputTemplate(`{{ len .Rows }} rows, {{ status .Status }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeOutput(&buf, tmpl, &outputData{Status: sensu.CheckStateWarning}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "# 0 rows, WARNING\n"; got != want {
+		t.Errorf("bad output: got %q, want %q", got, want)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	haproxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, err := w.Write(testDataCSV); err != nil {
+			panic(err)
+		}
+	}))
+	defer haproxy.Close()
+	u, err := url.Parse(haproxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withConfig(defaultConfig(), func() {
+		var buf bytes.Buffer
+		status, err := checkURL(&buf, u, &stateFile{Targets: make(map[string]*snapshot)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := status, sensu.CheckStateOK; got != want {
+			t.Errorf("bad status without --alert: got %d, want %d", got, want)
+		}
+		if !strings.HasPrefix(buf.String(), "# CRITICAL: HAProxy "+haproxy.URL+"\n") {
+			t.Errorf("output does not start with summary:\n%s", buf.String())
+		}
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(&buf)
+		if err != nil {
+			t.Fatalf("output is not valid prometheus text: %s", err)
+		}
+		if _, ok := families["haproxy_smax"]; !ok {
+			t.Error("haproxy_smax missing from output")
+		}
+
+		config.Alert = true
+		status, err = checkURL(&buf, u, &stateFile{Targets: make(map[string]*snapshot)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := status, sensu.CheckStateCritical; got != want {
+			t.Errorf("bad status with --alert: got %d, want %d", got, want)
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// With --peers, the peers sections that replicate stick tables between
+// HAProxy instances are read from the admin socket with show peers. When a
+// peer link breaks, the tables are no longer replicated, so a failover loses
+// whatever they track, such as rate limits.
+
+type peersSection struct {
+	Name string
+	// ResyncFinished is whether the initial resync of the tables, from the
+	// old local process or from a remote peer, has completed.
+	ResyncFinished bool
+	Peers          []peer
+}
+
+type peer struct {
+	Name   string
+	Local  bool
+	Addr   string
+	Status string
+	// LastHandshake is the time since the last successful handshake, or -1
+	// if there has never been one or HAProxy does not report it.
+	LastHandshake time.Duration
+	// Pending are the local updates of every shared table that have not
+	// been pushed to the peer, by table.
+	Pending map[string]float64
+}
+
+// connected reports whether the peer has an established connection.
+func (p peer) connected() bool {
+	return p.Status == "ESTA"
+}
+
+// The section flags that are both set once the resync has finished.
+const peersResyncFinished = 0x3
+
+// readPeers reads the peers sections from the admin socket at url.
+func readPeers(url *url.URL) ([]peersSection, error) {
+	data, err := runCommand(url, "show peers")
+	if err != nil {
+		return nil, err
+	}
+	return parsePeers(data), nil
+}
+
+// parsePeers parses the output of show peers. Every section starts with a
+// line with its address, a date and its id, followed by its peers, each
+// starting with a line with its address and "id=name(local)" or
+// "id=name(remote)". The shared tables of a peer list the last update pushed
+// to the peer, followed by the table with its latest local update.
+func parsePeers(data []byte) []peersSection {
+	var sections []peersSection
+	var lastPushed float64
+	for _, line := range strings.Split(string(data), "\n") {
+		tokens := strings.Fields(line)
+		if len(tokens) == 0 {
+			continue
+		}
+		fields := keyValueFields(tokens)
+		switch {
+		case strings.HasPrefix(tokens[0], "0x") && len(tokens) > 1 && strings.HasPrefix(tokens[1], "["):
+			flags, _ := strconv.ParseUint(strings.TrimPrefix(fields["flags"], "0x"), 16, 64)
+			sections = append(sections, peersSection{
+				Name:           fields["id"],
+				ResyncFinished: flags&peersResyncFinished == peersResyncFinished,
+			})
+		case strings.HasPrefix(tokens[0], "0x") && strings.Contains(fields["id"], "(") && len(sections) > 0:
+			id := fields["id"]
+			name, kind := id[:strings.Index(id, "(")], id[strings.Index(id, "(")+1:]
+			status, ok := fields["last_status"]
+			if !ok {
+				status = fields["status"]
+			}
+			lastPushed = 0
+			s := &sections[len(sections)-1]
+			s.Peers = append(s.Peers, peer{
+				Name:          name,
+				Local:         strings.HasPrefix(kind, "local"),
+				Addr:          fields["addr"],
+				Status:        status,
+				LastHandshake: parseHAProxyDuration(fields["last_hdshk"]),
+				Pending:       make(map[string]float64),
+			})
+		case len(sections) > 0 && len(sections[len(sections)-1].Peers) > 0:
+			s := &sections[len(sections)-1]
+			p := &s.Peers[len(s.Peers)-1]
+			if value, ok := fields["last_pushed"]; ok {
+				lastPushed, _ = strconv.ParseFloat(value, 64)
+			}
+			if strings.HasPrefix(tokens[0], "table:") {
+				update, ok := fields["localupdate"]
+				if !ok {
+					update = fields["update"]
+				}
+				if local, err := strconv.ParseFloat(update, 64); err == nil && local > lastPushed {
+					p.Pending[fields["id"]] = local - lastPushed
+				} else {
+					p.Pending[fields["id"]] = 0
+				}
+			}
+		}
+	}
+	return sections
+}
+
+// keyValueFields returns the key=value tokens of a line of show peers or
+// show sess.
+func keyValueFields(tokens []string) map[string]string {
+	fields := make(map[string]string)
+	for _, token := range tokens {
+		parts := strings.SplitN(token, "=", 2)
+		if len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+	return fields
+}
+
+// parseHAProxyDuration parses the human-readable durations of HAProxy, such as
+// 2m31s or 1d3h, returning -1 for <NEVER> and anything else it cannot parse.
+func parseHAProxyDuration(s string) time.Duration {
+	var days time.Duration
+	if i := strings.Index(s, "d"); i >= 0 {
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return -1
+		}
+		days, s = time.Duration(n)*24*time.Hour, s[i+1:]
+		if s == "" {
+			return days
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return -1
+	}
+	return days + d
+}
+
+func exportPeerMetrics(e *exporter, s *socketData) {
+	resync := e.gauge("haproxy_peers_resync_finished", "whether the resync of the peers section has finished", "section")
+	connected := e.gauge("haproxy_peer_connected", "whether the remote peer is connected", "section", "peer")
+	handshake := e.gauge("haproxy_peer_last_handshake_seconds", "seconds since the last handshake with the peer", "section", "peer")
+	pending := e.gauge("haproxy_peer_pending_updates", "stick table updates not yet pushed to the peer", "section", "peer", "table")
+	for _, section := range s.peers {
+		var value float64
+		if section.ResyncFinished {
+			value = 1
+		}
+		resync.WithLabelValues(section.Name).Set(value)
+		for _, p := range section.Peers {
+			if p.Local {
+				continue
+			}
+			var value float64
+			if p.connected() {
+				value = 1
+			}
+			connected.WithLabelValues(section.Name, p.Name).Set(value)
+			if p.LastHandshake >= 0 {
+				handshake.WithLabelValues(section.Name, p.Name).Set(p.LastHandshake.Seconds())
+			}
+			for table, updates := range p.Pending {
+				pending.WithLabelValues(section.Name, p.Name, table).Set(updates)
+			}
+		}
+	}
+}
+
+// peersRule is critical about remote peers that are not connected, and warns
+// about sections whose resync has not finished.
+func peersRule(e *evaluation) []result {
+	if e.socket == nil {
+		return nil
+	}
+	var results []result
+	for _, section := range e.socket.peers {
+		if !section.ResyncFinished {
+			results = append(results, result{
+				Subject: section.Name,
+				Type:    "peers",
+				Status:  sensu.CheckStateWarning,
+				Output:  fmt.Sprintf("peers %s resync has not finished", section.Name),
+			})
+		}
+		for _, p := range section.Peers {
+			if p.Local || p.connected() {
+				continue
+			}
+			output := fmt.Sprintf("peer %s/%s (%s) is not connected, status %s", section.Name, p.Name, p.Addr, p.Status)
+			if p.LastHandshake >= 0 {
+				output += fmt.Sprintf(", last handshake %s ago", p.LastHandshake)
+			}
+			var pending float64
+			for _, updates := range p.Pending {
+				pending += updates
+			}
+			if pending > 0 {
+				output += fmt.Sprintf(", %.0f updates pending", pending)
+			}
+			results = append(results, result{
+				Subject: section.Name + "/" + p.Name,
+				Type:    "peer",
+				Status:  sensu.CheckStateCritical,
+				Output:  output,
+			})
+		}
+	}
+	return results
+}
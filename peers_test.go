@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+const peersOutput = `0x55deb0224320: [15/Apr/2019:11:28:01] id=tpeers disabled=0 flags=0x3 resync_timeout=<PAST> task_calls=45122
+  0x55deb022b540: id=tpeer2(remote,active) addr=127.0.0.1:10002 last_status=CONN last_hdshk=1d2h reconnect=3s confirm=0
+      flags=0x0
+        shared tables:
+          0x55deb0224a10 local_id=1 remote_id=1 flags=0x0 remote_data=0x65
+              last_acked=0 last_pushed=3 last_get=0 teaching_origin=0 update=3
+              table:0x55deb022d6a0 id=stkt update=10 localupdate=10 commitupdate=3 syncing=0
+  0x55deb022a440: id=tpeer1(local,inactive) addr=127.0.0.1:10001 last_status=NONE last_hdshk=<NEVER> reconnect=<NEVER> confirm=0
+      flags=0x0
+  0x55deb02297a0: id=s2(remote,active) addr=127.0.0.1:10003 last_status=ESTA last_hdshk=2m31s reconnect=2s confirm=0
+      flags=0x20000200 appctx:0x55deb028fba0 st0=7 st1=0 task_calls=14456 state=EST
+        shared tables:
+          0x55deb0224a10 local_id=1 remote_id=1 flags=0x0 remote_data=0x65
+              last_acked=0 last_pushed=10 last_get=0 teaching_origin=0 update=10
+              table:0x55deb022d6a0 id=stkt update=10 localupdate=10 commitupdate=10 syncing=0
+
+0x55deb0225000: [15/Apr/2019:11:28:01] id=other disabled=0 flags=0x1 resync_timeout=5s task_calls=1
+  0x55deb0226000: id=o1(remote) addr=127.0.0.1:10004 status=ESTA reconnect=2s confirm=0
+`
+
+func TestParsePeers(t *testing.T) {
+	sections := parsePeers([]byte(peersOutput))
+	if len(sections) != 2 {
+		t.Fatalf("expected two sections, got %v", sections)
+	}
+	s := sections[0]
+	if s.Name != "tpeers" || !s.ResyncFinished || len(s.Peers) != 3 {
+		t.Fatalf("bad section: %+v", s)
+	}
+	if sections[1].ResyncFinished {
+		t.Error("resync of section other should not have finished")
+	}
+	p := s.Peers[0]
+	if p.Name != "tpeer2" || p.Local || p.connected() || p.Addr != "127.0.0.1:10002" {
+		t.Errorf("bad peer: %+v", p)
+	}
+	if got, want := p.LastHandshake, 26*time.Hour; got != want {
+		t.Errorf("bad last handshake: got %s, want %s", got, want)
+	}
+	if got, want := p.Pending["stkt"], float64(7); got != want {
+		t.Errorf("bad pending updates: got %v, want %v", got, want)
+	}
+	if !s.Peers[1].Local || s.Peers[1].LastHandshake != -1 {
+		t.Errorf("bad local peer: %+v", s.Peers[1])
+	}
+	if p := s.Peers[2]; !p.connected() || p.LastHandshake != 151*time.Second || p.Pending["stkt"] != 0 {
+		t.Errorf("bad connected peer: %+v", p)
+	}
+	if p := sections[1].Peers[0]; !p.connected() {
+		t.Errorf("peer with status=ESTA not connected: %+v", p)
+	}
+}
+
+func TestPeersRule(t *testing.T) {
+	results := peersRule(&evaluation{socket: &socketData{peers: parsePeers([]byte(peersOutput))}})
+	want := []struct {
+		status int
+		output string
+	}{
+		{sensu.CheckStateCritical, "peer tpeers/tpeer2 (127.0.0.1:10002) is not connected, status CONN, last handshake 26h0m0s ago, 7 updates pending"},
+		{sensu.CheckStateWarning, "peers other resync has not finished"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("bad results: got %v", results)
+	}
+	for i, r := range results {
+		if r.Status != want[i].status || r.Output != want[i].output {
+			t.Errorf("bad result %d:\ngot  %d %q\nwant %d %q", i, r.Status, r.Output, want[i].status, want[i].output)
+		}
+	}
+}
+
+func TestPeerMetrics(t *testing.T) {
+	e := newExporter()
+	exportPeerMetrics(e, &socketData{peers: parsePeers([]byte(peersOutput))})
+	text := exporterText(t, e)
+	wantMetrics(t, text,
+		`haproxy_peers_resync_finished{section="tpeers"} 1`,
+		`haproxy_peer_connected{peer="tpeer2",section="tpeers"} 0`,
+		`haproxy_peer_connected{peer="s2",section="tpeers"} 1`,
+		`haproxy_peer_last_handshake_seconds{peer="s2",section="tpeers"} 151`,
+		`haproxy_peer_pending_updates{peer="tpeer2",section="tpeers",table="stkt"} 7`,
+	)
+	if strings.Contains(text, `peer="tpeer1"`) {
+		t.Error("metrics exported for the local peer")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// With --pools, HAProxy's memory pools are read from the admin socket with
+// show pools, and with --activity, the counters of its event loop with show
+// activity. A pool allocation failure means that HAProxy ran into its memory
+// limit or out of memory, and had to fail whatever needed the memory.
+
+type memoryPool struct {
+	Name      string
+	Size      float64
+	Allocated float64
+	Bytes     float64
+	Used      float64
+	Failures  float64
+	Users     float64
+}
+
+// poolRE matches a pool in show pools. Depending on the version, there is
+// more between the fields, such as "(~3 by thread caches)" after the used
+// objects, or "needed_avg 4," before the failures.
+var poolRE = regexp.MustCompile(`Pool (\S+) \((\d+) bytes[^)]*\)\s*: (\d+) allocated \((\d+) bytes\), (\d+) used\b.*? (\d+) failures, (\d+) users`)
+
+// activityIgnored are the show activity fields that are not counters.
+var activityIgnored = []string{"thread_id", "date_now", "uptime_now"}
+
+// readPools reads the memory pools from the admin socket at url.
+func readPools(url *url.URL) ([]memoryPool, error) {
+	data, err := runCommand(url, "show pools")
+	if err != nil {
+		return nil, err
+	}
+	return parsePools(data), nil
+}
+
+// parsePools parses the output of show pools, with a line per pool like
+// "- Pool buffer (16384 bytes) : 5 allocated (81920 bytes), 3 used (~1 by
+// thread caches), needed_avg 4, 0 failures, 2 users".
+func parsePools(data []byte) []memoryPool {
+	var pools []memoryPool
+	for _, line := range strings.Split(string(data), "\n") {
+		m := poolRE.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		values := make([]float64, 6)
+		for i := range values {
+			values[i], _ = strconv.ParseFloat(m[i+2], 64)
+		}
+		pools = append(pools, memoryPool{
+			Name:      m[1],
+			Size:      values[0],
+			Allocated: values[1],
+			Bytes:     values[2],
+			Used:      values[3],
+			Failures:  values[4],
+			Users:     values[5],
+		})
+	}
+	return pools
+}
+
+// readActivity reads the activity counters from the admin socket at url.
+func readActivity(url *url.URL) (map[string]float64, error) {
+	data, err := runCommand(url, "show activity")
+	if err != nil {
+		return nil, err
+	}
+	return parseActivity(data), nil
+}
+
+// parseActivity parses the output of show activity, which lists the total of
+// every counter, followed by the value of every thread, like
+// "loops: 1234 [ 300 310 312 312 ]". Only the totals are kept.
+func parseActivity(data []byte) map[string]float64 {
+	activity := make(map[string]float64)
+	for key, value := range parseFields(data) {
+		if contains(activityIgnored, key) {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		if total, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			activity[key] = total
+		}
+	}
+	return activity
+}
+
+// stateKey is the key of the pool's counters in the state file.
+func (p memoryPool) stateKey() string {
+	return "pools/" + p.Name
+}
+
+func exportPoolMetrics(e *exporter, s *socketData) {
+	if len(s.pools) == 0 {
+		return
+	}
+	for _, m := range []struct {
+		name, help string
+		value      func(p memoryPool) float64
+	}{
+		{"haproxy_pool_size_bytes", "size of the objects of the memory pool", func(p memoryPool) float64 { return p.Size }},
+		{"haproxy_pool_allocated", "objects allocated in the memory pool", func(p memoryPool) float64 { return p.Allocated }},
+		{"haproxy_pool_allocated_bytes", "bytes allocated in the memory pool", func(p memoryPool) float64 { return p.Bytes }},
+		{"haproxy_pool_used", "objects used in the memory pool", func(p memoryPool) float64 { return p.Used }},
+		{"haproxy_pool_failures", "failed allocations in the memory pool", func(p memoryPool) float64 { return p.Failures }},
+		{"haproxy_pool_users", "users of the memory pool", func(p memoryPool) float64 { return p.Users }},
+	} {
+		gauge := e.gauge(m.name, m.help, "pool")
+		for _, p := range s.pools {
+			gauge.WithLabelValues(p.Name).Set(m.value(p))
+		}
+	}
+}
+
+// invalidMetricNameRE matches the characters of activity counters that are
+// not valid in a metric name.
+var invalidMetricNameRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// exportActivityMetrics exports every activity counter as a metric named after
+// the counter, with the characters that are not valid in a metric name
+// replaced. Of counters that end up with the same name, only the first in
+// sorted order is exported.
+func exportActivityMetrics(e *exporter, s *socketData) {
+	keys := make([]string, 0, len(s.activity))
+	for key := range s.activity {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	exported := make(map[string]bool)
+	for _, key := range keys {
+		name := "haproxy_activity_" + invalidMetricNameRE.ReplaceAllString(key, "_")
+		if exported[name] {
+			continue
+		}
+		exported[name] = true
+		e.gauge(name, "activity "+key).WithLabelValues().Set(s.activity[key])
+	}
+}
+
+// poolRule judges every memory pool by its allocation failures since the
+// previous run, or since HAProxy started if it was restarted since. Without a
+// previous run, the rule has nothing to compare with: the failures since
+// HAProxy started may be long over, and would keep the check WARNING until
+// the next restart.
+func poolRule(e *evaluation) []result {
+	if e.socket == nil || e.previous == nil {
+		return nil
+	}
+	var results []result
+	for _, p := range e.socket.pools {
+		failures, since := p.Failures, "since HAProxy started"
+		if previous, ok := e.socketCounters(p.stateKey()); ok && previous["failures"] <= p.Failures {
+			failures, since = p.Failures-previous["failures"], "since the previous run"
+		}
+		status := sensu.CheckStateOK
+		switch {
+		case config.PoolFailureCritical > 0 && failures >= float64(config.PoolFailureCritical):
+			status = sensu.CheckStateCritical
+		case config.PoolFailureWarning > 0 && failures >= float64(config.PoolFailureWarning):
+			status = sensu.CheckStateWarning
+		default:
+			continue
+		}
+		results = append(results, result{
+			Subject: p.Name,
+			Type:    "pool",
+			Status:  status,
+			Output:  fmt.Sprintf("pool %s failed %.0f allocations %s (%.0f allocated, %.0f used, %.0f bytes each)", p.Name, failures, since, p.Allocated, p.Used, p.Size),
+		})
+	}
+	return results
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+const poolsOutput = `Dumping pools usage. Use SIGQUIT to flush them.
+  - Pool comp_state (32 bytes) : 5 allocated (160 bytes), 5 used, needed_avg 3, 0 failures, 2 users, @0x561e8d3a4f00 [SHARED]
+  - Pool buffer (16384 bytes) : 1024 allocated (16777216 bytes), 1020 used, needed_avg 1000, 7 failures, 1 users, @0x561e8d3a5000
+  - Pool pipe (32 bytes) : 2 allocated (64 bytes), 1 used, 0 failures, 1 users [SHARED]
+  - Pool h2s (208 bytes) : 40 allocated (8320 bytes), 38 used (~2 by thread caches), needed_avg 35, 0 failures, 3 users, @0x561e8d3a5100 [SHARED]
+Total: 4 pools, 16785760 bytes allocated, 16785056 used.
+`
+
+const activityOutput = `thread_id: 1 (1..4)
+date_now: 1614081024.123456
+ctxsw: 123 [ 30 31 31 31 ]
+tasksw: 45 [ 11 11 11 12 ]
+loops: 1234 [ 300 310 312 312 ]
+poll_io: 567 [ 140 142 142 143 ]
+pool_fail: 7 [ 7 0 0 0 ]
+accepted: 10
+`
+
+func TestParsePools(t *testing.T) {
+	pools := parsePools([]byte(poolsOutput))
+	if len(pools) != 4 {
+		t.Fatalf("expected four pools, got %v", pools)
+	}
+	want := memoryPool{Name: "buffer", Size: 16384, Allocated: 1024, Bytes: 16777216, Used: 1020, Failures: 7, Users: 1}
+	if pools[1] != want {
+		t.Errorf("bad pool: got %+v, want %+v", pools[1], want)
+	}
+	if pools[2].Name != "pipe" || pools[2].Allocated != 2 {
+		t.Errorf("bad pool of an older version: %+v", pools[2])
+	}
+	want = memoryPool{Name: "h2s", Size: 208, Allocated: 40, Bytes: 8320, Used: 38, Users: 3}
+	if pools[3] != want {
+		t.Errorf("bad pool with thread caches: got %+v, want %+v", pools[3], want)
+	}
+}
+
+func TestParseActivity(t *testing.T) {
+	activity := parseActivity([]byte(activityOutput))
+	if activity["loops"] != 1234 || activity["pool_fail"] != 7 || activity["accepted"] != 10 {
+		t.Errorf("bad activity: %v", activity)
+	}
+	if _, ok := activity["date_now"]; ok {
+		t.Error("date_now is not a counter")
+	}
+}
+
+func TestPoolRule(t *testing.T) {
+	socket := &socketData{pools: parsePools([]byte(poolsOutput))}
+	cfg := defaultConfig()
+	cfg.PoolFailureWarning = 1
+	cfg.PoolFailureCritical = 5
+	withConfig(cfg, func() {
+		if results := poolRule(&evaluation{socket: socket}); len(results) != 0 {
+			t.Errorf("expected no results without state, got %v", results)
+		}
+
+		previous := &snapshot{Time: time.Now(), Socket: socket.counters()}
+		previous.Socket["pools/buffer"]["failures"] = 5
+		results := poolRule(&evaluation{socket: socket, previous: previous})
+		if len(results) != 1 || results[0].Status != sensu.CheckStateWarning {
+			t.Fatalf("expected one warning, got %v", results)
+		}
+		want := "pool buffer failed 2 allocations since the previous run (1024 allocated, 1020 used, 16384 bytes each)"
+		if got := results[0].Output; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+
+		previous.Socket["pools/buffer"]["failures"] = 7
+		if results := poolRule(&evaluation{socket: socket, previous: previous}); len(results) != 0 {
+			t.Errorf("expected no results without new failures, got %v", results)
+		}
+
+		// HAProxy restarted, so the counters started over.
+		previous.Socket["pools/buffer"]["failures"] = 9
+		results = poolRule(&evaluation{socket: socket, previous: previous})
+		if len(results) != 1 || results[0].Status != sensu.CheckStateCritical {
+			t.Fatalf("expected one critical result, got %v", results)
+		}
+		want = "pool buffer failed 7 allocations since HAProxy started (1024 allocated, 1020 used, 16384 bytes each)"
+		if got := results[0].Output; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+	})
+}
+
+func TestPoolMetrics(t *testing.T) {
+	e := newExporter()
+	s := &socketData{pools: parsePools([]byte(poolsOutput)), activity: parseActivity([]byte(activityOutput))}
+	exportPoolMetrics(e, s)
+	exportActivityMetrics(e, s)
+	wantMetrics(t, exporterText(t, e),
+		`haproxy_pool_allocated{pool="buffer"} 1024`,
+		`haproxy_pool_allocated_bytes{pool="buffer"} 1.6777216e+07`,
+		`haproxy_pool_failures{pool="buffer"} 7`,
+		`haproxy_pool_used{pool="comp_state"} 5`,
+		"haproxy_activity_loops 1234",
+		"haproxy_activity_poll_io 567",
+	)
+}
+
+func TestActivityMetricsInvalidNames(t *testing.T) {
+	e := newExporter()
+	s := &socketData{activity: map[string]float64{"fd lock": 3, "fd_lock": 4, "ctr-2": 5, "loops": 6}}
+	exportActivityMetrics(e, s)
+	wantMetrics(t, exporterText(t, e),
+		"haproxy_activity_ctr_2 5",
+		"haproxy_activity_fd_lock 3",
+		"haproxy_activity_loops 6",
+	)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// HAProxy 2.4 and later report the counters of its multiplexers in columns
+// prefixed with the protocol, after the "-" column. They are exported without
+// the prefix, with a protocol label instead, so that the same counters of
+// different protocols share a metric.
+
+var protocols = []string{"h2"}
+
+var protocolHelp = map[string]string{
+	"headers_rcvd":                  "HEADERS frames received",
+	"data_rcvd":                     "DATA frames received",
+	"settings_rcvd":                 "SETTINGS frames received",
+	"rst_stream_rcvd":               "RST_STREAM frames received",
+	"goaway_rcvd":                   "GOAWAY frames received",
+	"detected_conn_protocol_errors": "connection protocol errors",
+	"detected_strm_protocol_errors": "stream protocol errors",
+	"rst_stream_resp":               "RST_STREAM frames sent",
+	"goaway_resp":                   "GOAWAY frames sent",
+	"open_connections":              "open connections",
+	"backend_open_streams":          "open backend streams",
+	"total_connections":             "total connections",
+	"backend_total_streams":         "total backend streams",
+}
+
+var protocolNames = map[string]string{
+	"h2": "HTTP/2",
+}
+
+// protocolErrorColumns are the columns that protocol error thresholds can be
+// set for.
+var protocolErrorColumns = []string{
+	"h2_detected_conn_protocol_errors",
+	"h2_detected_strm_protocol_errors",
+}
+
+// exportProtocolMetrics exports the protocol columns of every row.
+func exportProtocolMetrics(e *exporter, rows []statRow, previous *snapshot) {
+	labels := append(append([]string{}, tags...), "protocol")
+	for _, row := range rows {
+		for column := range row {
+			for _, protocol := range protocols {
+				name := strings.TrimPrefix(column, protocol+"_")
+				if name == column {
+					continue
+				}
+				value, ok := row.Float(column)
+				if !ok {
+					continue
+				}
+				gauge := e.gauge("haproxy_"+name, protocolHelp[name], labels...)
+				gauge.WithLabelValues(append(rowLabels(row), protocol)...).Set(value)
+			}
+		}
+	}
+}
+
+// protocolErrorRule judges the protocol errors that frontends and backends
+// detected since the previous run against the --protocol-error-warning and
+// --protocol-error-critical thresholds.
+func protocolErrorRule(e *evaluation) []result {
+	warning, _ := parseThresholds(config.ProtocolErrorWarning, protocolErrorColumns...)
+	critical, _ := parseThresholds(config.ProtocolErrorCritical, protocolErrorColumns...)
+	if len(warning) == 0 && len(critical) == 0 {
+		return nil
+	}
+	var results []result
+	for _, row := range e.rows {
+		if row.Type() != "frontend" && row.Type() != "backend" {
+			continue
+		}
+		for _, column := range protocolErrorColumns {
+			errors, ok := e.delta(row, column)
+			if !ok {
+				continue
+			}
+			if t, ok := findThreshold(critical, row, column); ok && errors > t.value {
+				results = append(results, newResult(row, sensu.CheckStateCritical, "%s", protocolErrorOutput(row, column, errors, t.value)))
+			} else if t, ok := findThreshold(warning, row, column); ok && errors > t.value {
+				results = append(results, newResult(row, sensu.CheckStateWarning, "%s", protocolErrorOutput(row, column, errors, t.value)))
+			}
+		}
+	}
+	return results
+}
+
+func protocolErrorOutput(row statRow, column string, errors, limit float64) string {
+	parts := strings.SplitN(column, "_", 2)
+	return fmt.Sprintf("%s %s detected %.0f %s %s since the previous run, above %.0f", row.Type(), row.Proxy(), errors, protocolNames[parts[0]], protocolHelp[parts[1]], limit)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func protocolsCSV(t *testing.T) []byte {
+	return setColumns(t, testDataCSV, "main", "FRONTEND", map[string]string{
+		"h2_headers_rcvd":                  "1000",
+		"h2_detected_conn_protocol_errors": "30",
+		"h2_detected_strm_protocol_errors": "4",
+		"h2_open_connections":              "7",
+	})
+}
+
+func TestProtocolMetrics(t *testing.T) {
+	text := metricsText(t, protocolsCSV(t), nil)
+	wantMetrics(t, text,
+		`haproxy_headers_rcvd{host="",protocol="h2",proxy="main",sv="FRONTEND",type="frontend"} 1000`,
+		`haproxy_detected_conn_protocol_errors{host="",protocol="h2",proxy="main",sv="FRONTEND",type="frontend"} 30`,
+		`haproxy_open_connections{host="",protocol="h2",proxy="main",sv="FRONTEND",type="frontend"} 7`,
+		"# HELP haproxy_detected_strm_protocol_errors stream protocol errors",
+	)
+	if strings.Contains(text, `haproxy_headers_rcvd{host="",protocol="h2",proxy="app",sv="app1"`) {
+		t.Error("protocol metric exported for row without a value")
+	}
+}
+
+func TestProtocolErrorRule(t *testing.T) {
+	var rows []statRow
+	for _, row := range testRows(t, protocolsCSV(t)) {
+		if row.Proxy() != "stats" {
+			rows = append(rows, row)
+		}
+	}
+	cfg := defaultConfig()
+	cfg.ProtocolErrorWarning = []string{"h2_detected_conn_protocol_errors=5", "h2_detected_strm_protocol_errors=5"}
+	cfg.ProtocolErrorCritical = []string{"main:h2_detected_conn_protocol_errors=10"}
+	withConfig(cfg, func() {
+		e := previousRun(rows, "FRONTEND", map[string]float64{
+			"h2_detected_conn_protocol_errors": 12,
+			"h2_detected_strm_protocol_errors": 3,
+		})
+		results := protocolErrorRule(e)
+		if len(results) != 1 {
+			t.Fatalf("expected one result, got %v", results)
+		}
+		if got, want := results[0].Status, sensu.CheckStateCritical; got != want {
+			t.Errorf("bad status: got %d, want %d", got, want)
+		}
+		want := "frontend main detected 12 HTTP/2 connection protocol errors since the previous run, above 10"
+		if got := results[0].Output; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+	})
+}
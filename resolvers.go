@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// With --resolvers, the counters of every nameserver of HAProxy's resolvers
+// sections are read from the admin socket with show resolvers. Server
+// templates depend on these resolvers, so a nameserver that stops answering
+// silently shrinks the backends that use them.
+
+type nameserver struct {
+	Resolvers string
+	Name      string
+	Counters  map[string]float64
+}
+
+// resolverErrorCounters are the counters of failed queries that the
+// error_rate threshold is compared with. Timeouts are judged separately, and
+// NXDOMAIN responses are valid answers.
+var resolverErrorCounters = []string{"snd_error", "cname_error", "refused", "other", "invalid", "too_big", "error"}
+
+var resolverRateNames = []string{"error_rate", "timeout_rate"}
+
+// readResolvers reads the nameservers of every resolvers section from the
+// admin socket at url.
+func readResolvers(url *url.URL) ([]nameserver, error) {
+	data, err := runCommand(url, "show resolvers")
+	if err != nil {
+		return nil, err
+	}
+	return parseResolvers(data), nil
+}
+
+// parseResolvers parses the output of show resolvers, which lists the
+// counters of every nameserver under its resolvers section:
+//
+//	Resolvers section mydns
+//	 nameserver dns1:
+//	  sent:        8
+//	  timeout:     0
+func parseResolvers(data []byte) []nameserver {
+	var nameservers []nameserver
+	var section string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Resolvers section "):
+			section = strings.TrimPrefix(line, "Resolvers section ")
+		case strings.HasPrefix(line, "nameserver "):
+			name := strings.TrimSuffix(strings.TrimPrefix(line, "nameserver "), ":")
+			nameservers = append(nameservers, nameserver{
+				Resolvers: section,
+				Name:      name,
+				Counters:  make(map[string]float64),
+			})
+		default:
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) != 2 || len(nameservers) == 0 {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			if err != nil {
+				continue
+			}
+			nameservers[len(nameservers)-1].Counters[strings.TrimSpace(parts[0])] = value
+		}
+	}
+	return nameservers
+}
+
+// stateKey is the key of the nameserver's counters in the state file.
+func (ns nameserver) stateKey() string {
+	return "resolvers/" + ns.Resolvers + "/" + ns.Name
+}
+
+func exportResolverMetrics(e *exporter, s *socketData) {
+	for _, ns := range s.resolvers {
+		counters := make([]string, 0, len(ns.Counters))
+		for counter := range ns.Counters {
+			counters = append(counters, counter)
+		}
+		sort.Strings(counters)
+		for _, counter := range counters {
+			gauge := e.gauge("haproxy_resolver_"+counter, "resolver nameserver "+counter, "resolvers", "nameserver")
+			gauge.WithLabelValues(ns.Resolvers, ns.Name).Set(ns.Counters[counter])
+		}
+	}
+}
+
+// resolverRates returns the error and timeout rates of ns, as fractions of
+// the queries sent, and a description of what they were computed from. When
+// the previous run is known, only the queries since then are counted. ok is
+// false if no queries were sent.
+func resolverRates(e *evaluation, ns nameserver) (rates map[string]float64, description string, ok bool) {
+	counters := ns.Counters
+	since := ""
+	if previous, ok := e.socketCounters(ns.stateKey()); ok {
+		deltas := make(map[string]float64, len(counters))
+		reset := false
+		for counter, value := range counters {
+			if value < previous[counter] {
+				reset = true
+			}
+			deltas[counter] = value - previous[counter]
+		}
+		if !reset {
+			counters, since = deltas, " since the previous run"
+		}
+	}
+	sent := counters["sent"]
+	if sent == 0 {
+		return nil, "", false
+	}
+	var errors float64
+	for _, counter := range resolverErrorCounters {
+		errors += counters[counter]
+	}
+	rates = map[string]float64{
+		"error_rate":   errors / sent,
+		"timeout_rate": counters["timeout"] / sent,
+	}
+	return rates, fmt.Sprintf("%.0f errors and %.0f timeouts of %.0f queries%s", errors, counters["timeout"], sent, since), true
+}
+
+// resolverRule judges the error and timeout rates of every nameserver
+// against the --resolver-warning and --resolver-critical thresholds.
+func resolverRule(e *evaluation) []result {
+	warning, _ := parseThresholds(config.ResolverWarning, resolverRateNames...)
+	critical, _ := parseThresholds(config.ResolverCritical, resolverRateNames...)
+	if e.socket == nil || (len(warning) == 0 && len(critical) == 0) {
+		return nil
+	}
+	var results []result
+	for _, ns := range e.socket.resolvers {
+		rates, description, ok := resolverRates(e, ns)
+		if !ok {
+			continue
+		}
+		status := sensu.CheckStateOK
+		var exceeded []string
+		for _, name := range resolverRateNames {
+			for _, level := range []struct {
+				status     int
+				thresholds []threshold
+			}{
+				{sensu.CheckStateCritical, critical},
+				{sensu.CheckStateWarning, warning},
+			} {
+				t, ok := findScopedThreshold(level.thresholds, ns.Resolvers, ns.Name, name)
+				if !ok || rates[name] <= t.value {
+					continue
+				}
+				if severity(level.status) > severity(status) {
+					status = level.status
+				}
+				exceeded = append(exceeded, fmt.Sprintf("%s %.1f%% above %.1f%%", strings.Replace(name, "_", " ", 1), 100*rates[name], 100*t.value))
+				break
+			}
+		}
+		if status == sensu.CheckStateOK {
+			continue
+		}
+		results = append(results, result{
+			Subject: ns.Resolvers + "/" + ns.Name,
+			Type:    "nameserver",
+			Status:  status,
+			Output:  fmt.Sprintf("nameserver %s/%s %s (%s)", ns.Resolvers, ns.Name, strings.Join(exceeded, ", "), description),
+		})
+	}
+	return results
+}
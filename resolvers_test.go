@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+const resolversOutput = `Resolvers section mydns
+ nameserver dns1:
+  sent:        1000
+  snd_error:   0
+  valid:       900
+  update:      0
+  cname:       0
+  cname_error: 0
+  any_err:     0
+  nx:          40
+  timeout:     50
+  refused:     10
+  other:       0
+  invalid:     0
+  too_big:     0
+  truncated:   0
+  outdated:    0
+ nameserver dns2:
+  sent:        1000
+  valid:       1000
+  timeout:     0
+`
+
+func TestParseResolvers(t *testing.T) {
+	nameservers := parseResolvers([]byte(resolversOutput))
+	if len(nameservers) != 2 {
+		t.Fatalf("expected two nameservers, got %v", nameservers)
+	}
+	ns := nameservers[0]
+	if ns.Resolvers != "mydns" || ns.Name != "dns1" {
+		t.Errorf("bad nameserver: %s/%s", ns.Resolvers, ns.Name)
+	}
+	if ns.Counters["timeout"] != 50 || ns.Counters["nx"] != 40 || len(ns.Counters) != 15 {
+		t.Errorf("bad counters: %v", ns.Counters)
+	}
+}
+
+func TestResolverRule(t *testing.T) {
+	socket := &socketData{resolvers: parseResolvers([]byte(resolversOutput))}
+	cfg := defaultConfig()
+	cfg.ResolverWarning = []string{"timeout_rate=0.01", "error_rate=0.05"}
+	cfg.ResolverCritical = []string{"mydns/dns1:timeout_rate=0.04"}
+	withConfig(cfg, func() {
+		results := resolverRule(&evaluation{socket: socket})
+		if len(results) != 1 {
+			t.Fatalf("expected one result, got %v", results)
+		}
+		if got, want := results[0].Status, sensu.CheckStateCritical; got != want {
+			t.Errorf("bad status: got %d, want %d", got, want)
+		}
+		want := "nameserver mydns/dns1 timeout rate 5.0% above 4.0% (10 errors and 50 timeouts of 1000 queries)"
+		if got := results[0].Output; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+
+		// Since the previous run, dns1 timed out on all of its 10 queries,
+		// and dns2 sent none.
+		now := time.Now()
+		previous := &snapshot{Time: now.Add(-time.Minute), Socket: socket.counters()}
+		current := &socketData{resolvers: parseResolvers([]byte(resolversOutput))}
+		current.resolvers[0].Counters["sent"] += 10
+		current.resolvers[0].Counters["timeout"] += 10
+		results = resolverRule(&evaluation{previous: previous, now: now, socket: current})
+		if len(results) != 1 {
+			t.Fatalf("expected one result, got %v", results)
+		}
+		want = "nameserver mydns/dns1 timeout rate 100.0% above 4.0% (0 errors and 10 timeouts of 10 queries since the previous run)"
+		if got := results[0].Output; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+	})
+}
+
+func TestResolverMetrics(t *testing.T) {
+	e := newExporter()
+	exportResolverMetrics(e, &socketData{resolvers: parseResolvers([]byte(resolversOutput))})
+	wantMetrics(t, exporterText(t, e),
+		`haproxy_resolver_sent{nameserver="dns1",resolvers="mydns"} 1000`,
+		`haproxy_resolver_timeout{nameserver="dns1",resolvers="mydns"} 50`,
+		`haproxy_resolver_valid{nameserver="dns2",resolvers="mydns"} 1000`,
+	)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// Rules evaluate the scraped stats of a single target and produce results
+// for the proxies and servers that they judge. Every rule is run on every
+// evaluation; a rule that has nothing to say about a proxy returns no result
+// for it.
+
+// result is the outcome of evaluating a rule against a single row. Results
+// about something else than a proxy, such as a certificate, a stick table or
+// a memory pool, have no Proxy or Server, and name what they are about in
+// Subject instead.
+type result struct {
+	Proxy   string
+	Server  string
+	Subject string
+	Type    string
+	Status  int
+	Output  string
+}
+
+// evaluation holds everything the rules are evaluated against.
+type evaluation struct {
+	rows []statRow
+	// previous is the state of the previous run, or nil if there is none.
+	previous *snapshot
+	now      time.Time
+	// socket is the data read from the admin socket, or nil if the target is
+	// not an admin socket.
+	socket *socketData
+}
+
+// delta returns how much a counter column of row has grown since the previous
+// run. ok is false if there is no previous value, or if the counter went
+// backwards because HAProxy was restarted.
+func (e *evaluation) delta(row statRow, column string) (delta float64, ok bool) {
+	if e.previous == nil {
+		return 0, false
+	}
+	previous, ok := e.previous.Rows[rowKey(row)][column]
+	if !ok {
+		return 0, false
+	}
+	current, ok := row.Float(column)
+	if !ok || current < previous {
+		return 0, false
+	}
+	return current - previous, true
+}
+
+// socketCounters returns the counters stored under key by the previous run,
+// if there is one.
+func (e *evaluation) socketCounters(key string) (map[string]float64, bool) {
+	if e.previous == nil {
+		return nil, false
+	}
+	counters, ok := e.previous.Socket[key]
+	return counters, ok
+}
+
+// elapsed returns the time since the previous run, or 0 if there is none.
+func (e *evaluation) elapsed() time.Duration {
+	if e.previous == nil {
+		return 0
+	}
+	return e.now.Sub(e.previous.Time)
+}
+
+type rule func(e *evaluation) []result
+
+var rules = []rule{
+	healthRule,
+	maintenanceRule,
+	flappingRule,
+	latencyRule,
+	agentRule,
+	weightRule,
+	idlePoolRule,
+	cacheRule,
+	sslHandshakeRule,
+	protocolErrorRule,
+	certRule,
+	ocspRule,
+	tableRule,
+	resolverRule,
+	peersRule,
+	sessionRule,
+	poolRule,
+}
+
+// evaluate runs all of the rules, returning the results ordered by proxy,
+// and then with the worst results first.
+func evaluate(e *evaluation) []result {
+	var results []result
+	for _, rule := range rules {
+		results = append(results, rule(e)...)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Proxy != results[j].Proxy {
+			return results[i].Proxy < results[j].Proxy
+		}
+		return severity(results[i].Status) > severity(results[j].Status)
+	})
+	return results
+}
+
+// severity orders check statuses so that unknown sorts between warning and
+// critical.
+func severity(status int) int {
+	switch status {
+	case sensu.CheckStateOK:
+		return 0
+	case sensu.CheckStateWarning:
+		return 1
+	case sensu.CheckStateCritical:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// worst returns the worst status of results, or OK if there are none.
+func worst(results []result) int {
+	status := sensu.CheckStateOK
+	for _, r := range results {
+		if severity(r.Status) > severity(status) {
+			status = r.Status
+		}
+	}
+	return status
+}
+
+func newResult(row statRow, status int, format string, args ...interface{}) result {
+	return result{
+		Proxy:  row.Proxy(),
+		Server: row.Server(),
+		Type:   row.Type(),
+		Status: status,
+		Output: fmt.Sprintf(format, args...),
+	}
+}
+
+// serverUp reports whether HAProxy considers the server available. Servers
+// going down ("UP 1/3") are still up, servers coming up ("DOWN 1/2") are not.
+func serverUp(row statRow) bool {
+	status := row.String("status")
+	return strings.HasPrefix(status, "UP") || status == "no check"
+}
+
+// adminState returns the administrative state of a server, MAINT, DRAIN or
+// NOLB, or the empty string if the server is not in one. Servers are put in
+// these states deliberately, so they are not judged by their availability.
+// Servers inheriting maintenance from a tracked server report
+// "MAINT (via x/y)".
+func adminState(row statRow) string {
+	status := row.String("status")
+	for _, state := range []string{"MAINT", "DRAIN", "NOLB"} {
+		if status == state || strings.HasPrefix(status, state+" ") {
+			return state
+		}
+	}
+	return ""
+}
+
+// serverHealth returns the status of a single server based on its own health,
+// regardless of the availability of the rest of its backend.
+func serverHealth(row statRow) (int, string) {
+	if adminState(row) != "" {
+		return sensu.CheckStateOK, fmt.Sprintf("server %s/%s is in %s", row.Proxy(), row.Server(), row.String("status"))
+	}
+	if serverUp(row) {
+		return sensu.CheckStateOK, fmt.Sprintf("server %s/%s is %s", row.Proxy(), row.Server(), row.String("status"))
+	}
+	output := fmt.Sprintf("server %s/%s is %s", row.Proxy(), row.Server(), row.String("status"))
+	if details := checkDetails(row); details != "" {
+		output += ", " + details
+	}
+	return sensu.CheckStateCritical, output
+}
+
+// healthRule judges frontends by their status, and backends by the
+// percentage of their servers that are available.
+func healthRule(e *evaluation) []result {
+	var results []result
+	for _, row := range e.rows {
+		switch row.Type() {
+		case "frontend":
+			results = append(results, frontendHealth(row))
+		case "backend":
+			results = append(results, backendHealth(row, e.servers(row.Proxy())))
+		}
+	}
+	return results
+}
+
+func frontendHealth(row statRow) result {
+	switch status := row.String("status"); status {
+	case "OPEN":
+		return newResult(row, sensu.CheckStateOK, "frontend %s is OPEN", row.Proxy())
+	case "FULL":
+		return newResult(row, sensu.CheckStateWarning, "frontend %s is FULL", row.Proxy())
+	default:
+		return newResult(row, sensu.CheckStateCritical, "frontend %s is %s", row.Proxy(), status)
+	}
+}
+
+func backendHealth(row statRow, servers []statRow) result {
+	status := row.String("status")
+	if len(servers) == 0 {
+		if strings.HasPrefix(status, "UP") {
+			return newResult(row, sensu.CheckStateOK, "backend %s is %s", row.Proxy(), status)
+		}
+		return newResult(row, sensu.CheckStateCritical, "backend %s is %s", row.Proxy(), status)
+	}
+	var up, total int
+	var down, maintenance []string
+	for _, server := range servers {
+		if state := adminState(server); state != "" {
+			maintenance = append(maintenance, fmt.Sprintf("%s (%s)", server.Server(), state))
+			continue
+		}
+		total++
+		if serverUp(server) {
+			up++
+		} else {
+			down = append(down, server.Server())
+		}
+	}
+	if total == 0 {
+		output := fmt.Sprintf("backend %s is %s, all servers in maintenance: %s", row.Proxy(), status, strings.Join(maintenance, ", "))
+		if strings.HasPrefix(status, "UP") {
+			return newResult(row, sensu.CheckStateOK, "%s", output)
+		}
+		return newResult(row, sensu.CheckStateCritical, "%s", output)
+	}
+	available := 100 * float64(up) / float64(total)
+	output := fmt.Sprintf("backend %s is %s, %d/%d servers available (%.0f%%)", row.Proxy(), status, up, total, available)
+	if len(down) > 0 {
+		output += fmt.Sprintf(", unavailable: %s", strings.Join(down, ", "))
+	}
+	if len(maintenance) > 0 {
+		output += fmt.Sprintf(", in maintenance: %s", strings.Join(maintenance, ", "))
+	}
+	switch {
+	case status == "DOWN" || available < config.AvailableCritical:
+		return newResult(row, sensu.CheckStateCritical, "%s", output)
+	case available < config.AvailableWarning:
+		return newResult(row, sensu.CheckStateWarning, "%s", output)
+	default:
+		return newResult(row, sensu.CheckStateOK, "%s", output)
+	}
+}
+
+// servers returns the server rows of the named backend.
+func (e *evaluation) servers(proxy string) []statRow {
+	var servers []statRow
+	for _, row := range e.rows {
+		if row.Type() == "server" && row.Proxy() == proxy {
+			servers = append(servers, row)
+		}
+	}
+	return servers
+}
+
+// maintenanceRule warns about servers that have been in an administrative
+// state for longer than config.MaxMaintenanceSeconds, as they were probably
+// forgotten there.
+func maintenanceRule(e *evaluation) []result {
+	if config.MaxMaintenanceSeconds <= 0 {
+		return nil
+	}
+	max := time.Duration(config.MaxMaintenanceSeconds) * time.Second
+	var results []result
+	for _, row := range e.rows {
+		state := adminState(row)
+		if row.Type() != "server" || state == "" {
+			continue
+		}
+		lastchg, ok := row.Float("lastchg")
+		if !ok {
+			continue
+		}
+		since := time.Duration(lastchg) * time.Second
+		if since > max {
+			results = append(results, newResult(row, sensu.CheckStateWarning, "server %s/%s has been in %s for %s, longer than %s", row.Proxy(), row.Server(), state, since, max))
+		}
+	}
+	return results
+}
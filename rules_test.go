@@ -0,0 +1,190 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// withConfig runs fn with the global config replaced by cfg.
+func withConfig(cfg Config, fn func()) {
+	saved := config
+	config = cfg
+	defer func() {
+		config = saved
+	}()
+	fn()
+}
+
+func defaultConfig() Config {
+	return Config{
+		Mode:              "check",
+		AvailableWarning:  100,
+		AvailableCritical: 50,
+		FlapWarning:       2,
+		FlapCritical:      5,
+		FlapInterval:      600,
+		MinWeightPercent:  50,
+	}
+}
+
+// statusCSV replaces the status of the app servers in the test data. The app
+// backend is UP if any of its servers is.
+func statusCSV(statuses ...string) []byte {
+	lines := strings.Split(string(testDataCSV), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "app,BACKEND,") {
+			for _, status := range statuses {
+				if strings.HasPrefix(status, "UP") || status == "no check" {
+					lines[i] = strings.Replace(line, ",DOWN,", ",UP,", 1)
+					break
+				}
+			}
+		}
+		for j, status := range statuses {
+			prefix := "app,app" + string(rune('1'+j)) + ","
+			if strings.HasPrefix(line, prefix) {
+				lines[i] = strings.Replace(line, ",DOWN,", ","+status+",", 1)
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func findResult(results []result, proxy, server string) (result, bool) {
+	for _, r := range results {
+		if r.Proxy == proxy && r.Server == server {
+			return r, true
+		}
+	}
+	return result{}, false
+}
+
+func TestHealthRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		want     int
+	}{
+		{"all down", []string{"DOWN", "DOWN", "DOWN", "DOWN"}, sensu.CheckStateCritical},
+		{"all up", []string{"UP", "UP", "UP", "no check"}, sensu.CheckStateOK},
+		{"one down", []string{"UP", "UP", "UP 1/3", "DOWN 1/2"}, sensu.CheckStateWarning},
+		{"half down", []string{"UP", "DOWN", "UP", "DOWN"}, sensu.CheckStateWarning},
+		{"most down", []string{"UP", "DOWN", "DOWN", "DOWN"}, sensu.CheckStateCritical},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(defaultConfig(), func() {
+				results := evaluate(&evaluation{rows: testRows(t, statusCSV(test.statuses...))})
+				r, ok := findResult(results, "app", "BACKEND")
+				if !ok {
+					t.Fatal("no result for backend app")
+				}
+				if got, want := r.Status, test.want; got != want {
+					t.Errorf("bad status: got %d, want %d (%s)", got, want, r.Output)
+				}
+			})
+		})
+	}
+}
+
+func TestHealthRuleFrontends(t *testing.T) {
+	withConfig(defaultConfig(), func() {
+		results := evaluate(&evaluation{rows: testRows(t, testDataCSV)})
+		r, ok := findResult(results, "stats", "FRONTEND")
+		if !ok {
+			t.Fatal("no result for frontend stats")
+		}
+		if got, want := r.Status, sensu.CheckStateOK; got != want {
+			t.Errorf("bad status: got %d, want %d", got, want)
+		}
+		r, ok = findResult(results, "static", "BACKEND")
+		if !ok {
+			t.Fatal("no result for backend static")
+		}
+		if got, want := r.Output, "backend static is DOWN, 0/1 servers available (0%), unavailable: static"; got != want {
+			t.Errorf("bad output: got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestWorst(t *testing.T) {
+	results := []result{
+		{Status: sensu.CheckStateOK},
+		{Status: sensu.CheckStateUnknown},
+		{Status: sensu.CheckStateWarning},
+	}
+	if got, want := worst(results), sensu.CheckStateUnknown; got != want {
+		t.Errorf("bad status: got %d, want %d", got, want)
+	}
+	if got, want := worst(nil), sensu.CheckStateOK; got != want {
+		t.Errorf("bad status: got %d, want %d", got, want)
+	}
+}
+
+func TestHealthRuleMaintenance(t *testing.T) {
+	withConfig(defaultConfig(), func() {
+		rows := testRows(t, statusCSV("UP", "UP", "MAINT (via static/static)", "DRAIN"))
+		results := evaluate(&evaluation{rows: rows})
+		r, ok := findResult(results, "app", "BACKEND")
+		if !ok {
+			t.Fatal("no result for backend app")
+		}
+		if got, want := r.Status, sensu.CheckStateOK; got != want {
+			t.Errorf("bad status: got %d, want %d", got, want)
+		}
+		if got, want := r.Output, "backend app is UP, 2/2 servers available (100%), in maintenance: app3 (MAINT), app4 (DRAIN)"; got != want {
+			t.Errorf("bad output: got %q, want %q", got, want)
+		}
+		for _, row := range rows {
+			if row.Server() == "app3" {
+				if status, _ := serverHealth(row); status != sensu.CheckStateOK {
+					t.Errorf("bad status for server in maintenance: got %d", status)
+				}
+			}
+		}
+	})
+}
+
+func TestAdminState(t *testing.T) {
+	tests := map[string]string{
+		"MAINT":                     "MAINT",
+		"MAINT (via static/static)": "MAINT",
+		"MAINT (resolution)":        "MAINT",
+		"DRAIN":                     "DRAIN",
+		"NOLB":                      "NOLB",
+		"UP":                        "",
+		"DOWN":                      "",
+		"UP 1/3":                    "",
+		"no check":                  "",
+	}
+	for status, want := range tests {
+		if got := adminState(statRow{"status": status}); got != want {
+			t.Errorf("bad admin state for %q: got %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestMaintenanceRule(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.MaxMaintenanceSeconds = 3600
+	withConfig(cfg, func() {
+		rows := testRows(t, statusCSV("UP", "UP", "MAINT", "DOWN"))
+		results := maintenanceRule(&evaluation{rows: rows})
+		if got, want := len(results), 1; got != want {
+			t.Fatalf("bad result count: got %d, want %d", got, want)
+		}
+		if got, want := results[0].Output, "server app/app3 has been in MAINT for 20h13m23s, longer than 1h0m0s"; got != want {
+			t.Errorf("bad output: got %q, want %q", got, want)
+		}
+		if got, want := results[0].Status, sensu.CheckStateWarning; got != want {
+			t.Errorf("bad status: got %d, want %d", got, want)
+		}
+	})
+	withConfig(defaultConfig(), func() {
+		if results := maintenanceRule(&evaluation{rows: testRows(t, statusCSV("MAINT"))}); len(results) != 0 {
+			t.Errorf("expected no results when disabled, got %v", results)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// In serve mode, the check runs as a long-lived prometheus exporter instead of
+// exiting after printing its metrics. Every request to /metrics scrapes all of
+// the configured URLs, unless a scrape younger than the cache TTL is available.
+
+// serve runs the exporter on config.ListenAddress. It only returns if the
+// server fails.
+func serve(config Config) error {
+	urls := make([]*url.URL, 0, len(config.URLs))
+	for _, cfgURL := range config.URLs {
+		u, err := url.Parse(cfgURL)
+		if err != nil {
+			return err
+		}
+		urls = append(urls, u)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", newMetricsHandler(urls, config))
+	log.Printf("serving metrics on %s/metrics", config.ListenAddress)
+	return http.ListenAndServe(config.ListenAddress, mux)
+}
+
+type metricsHandler struct {
+	urls   []*url.URL
+	config Config
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	cached  []byte
+	expires time.Time
+}
+
+func newMetricsHandler(urls []*url.URL, config Config) *metricsHandler {
+	return &metricsHandler{
+		urls:   urls,
+		config: config,
+		ttl:    time.Duration(config.CacheSeconds) * time.Second,
+	}
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := h.metrics()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", string(expfmt.FmtText))
+	_, _ = w.Write(body)
+}
+
+// metrics returns the encoded metrics, scraping the URLs if the cached scrape
+// has expired. Concurrent requests wait for the same scrape.
+func (h *metricsHandler) metrics() ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cached != nil && time.Now().Before(h.expires) {
+		return h.cached, nil
+	}
+	var buf bytes.Buffer
+	if err := encodeMetrics(&buf, h.scrape()); err != nil {
+		return nil, err
+	}
+	h.cached = buf.Bytes()
+	h.expires = time.Now().Add(h.ttl)
+	return h.cached, nil
+}
+
+// scrape reads the stats from every URL in parallel, each into its own
+// exporter that labels the metrics with the URL, so that the series of
+// HAProxy instances with the same proxy names do not overwrite each other. A
+// URL that cannot be scraped is reported with haproxy_up, rather than failing
+// the whole request.
+func (h *metricsHandler) scrape() prometheus.Gatherers {
+	e := newExporter()
+	targets := make([]*exporter, len(h.urls))
+	up := e.gauge("haproxy_up", "whether the last scrape of haproxy was successful", "url")
+	duration := e.gauge("haproxy_exporter_scrape_duration_seconds", "duration of the last scrape of haproxy", "url")
+	var wg sync.WaitGroup
+	for i, u := range h.urls {
+		wg.Add(1)
+		go func(i int, u *url.URL) {
+			defer wg.Done()
+			label := stripFilterParams(u).Redacted()
+			targets[i] = newLabelledExporter(prometheus.Labels{"url": label})
+			start := time.Now()
+			err := h.scrapeURL(targets[i], u)
+			duration.WithLabelValues(label).Set(time.Since(start).Seconds())
+			if err != nil {
+				log.Printf("error scraping %s: %s", label, err)
+				up.WithLabelValues(label).Set(0)
+				return
+			}
+			up.WithLabelValues(label).Set(1)
+		}(i, u)
+	}
+	wg.Wait()
+	gatherers := prometheus.Gatherers{e.registry}
+	for _, target := range targets {
+		gatherers = append(gatherers, target.registry)
+	}
+	return gatherers
+}
+
+func (h *metricsHandler) scrapeURL(e *exporter, u *url.URL) error {
+	f, err := newFilter(h.config, u)
+	if err != nil {
+		return err
+	}
+	data, err := readStats(u, h.config)
+	if err != nil {
+		return err
+	}
+	db, err := createDB(data, f)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	socket, err := readSocketData(u, h.config)
+	if err != nil {
+		return err
+	}
+	socket.applyFilter(f)
+	return exportMetrics(e, db, socket, nil)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func getMetrics(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad status: %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	var scrapes int32
+	haproxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&scrapes, 1)
+		if _, err := w.Write(testDataCSV); err != nil {
+			panic(err)
+		}
+	}))
+	defer haproxy.Close()
+	good, err := url.Parse(haproxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := url.Parse("unix:///nonexistent/haproxy.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", newMetricsHandler([]*url.URL{good, bad}, Config{CacheSeconds: 60}))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	body := getMetrics(t, server)
+	wantMetrics(t, body,
+		`haproxy_smax{host="",proxy="stats",sv="FRONTEND",type="frontend",url="`+haproxy.URL+`"} 10`,
+		`haproxy_up{url="`+haproxy.URL+`"} 1`,
+		`haproxy_up{url="unix:///nonexistent/haproxy.sock"} 0`,
+		`haproxy_exporter_scrape_duration_seconds{url="`+haproxy.URL+`"}`,
+	)
+
+	if got := getMetrics(t, server); got != body {
+		t.Error("cached metrics differ")
+	}
+	if got, want := atomic.LoadInt32(&scrapes), int32(1); got != want {
+		t.Errorf("bad scrape count: got %d, want %d", got, want)
+	}
+}
+
+func TestMetricsHandlerTargets(t *testing.T) {
+	var urls []*url.URL
+	for _, smax := range []string{"111", "222"} {
+		csv := bytes.Replace(testDataCSV, []byte("stats,FRONTEND,,,2,10,"), []byte("stats,FRONTEND,,,2,"+smax+","), 1)
+		haproxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if _, err := w.Write(csv); err != nil {
+				panic(err)
+			}
+		}))
+		defer haproxy.Close()
+		u, err := url.Parse(haproxy.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, u)
+	}
+	server := httptest.NewServer(newMetricsHandler(urls, Config{}))
+	defer server.Close()
+
+	body := getMetrics(t, server)
+	for i, smax := range []string{"111", "222"} {
+		wantMetrics(t, body, `haproxy_smax{host="",proxy="stats",sv="FRONTEND",type="frontend",url="`+urls[i].String()+`"} `+smax)
+	}
+}
+
+func TestMetricsHandlerFilteredTarget(t *testing.T) {
+	haproxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, err := w.Write(testDataCSV); err != nil {
+			panic(err)
+		}
+	}))
+	defer haproxy.Close()
+	u, err := url.Parse(haproxy.URL + "/stats?scope=app&include-proxy=stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(newMetricsHandler([]*url.URL{u}, Config{}))
+	defer server.Close()
+
+	body := getMetrics(t, server)
+	label := `url="` + haproxy.URL + `/stats?scope=app"`
+	wantMetrics(t, body,
+		`haproxy_up{`+label+`} 1`,
+		`haproxy_smax{host="",proxy="stats",sv="FRONTEND",type="frontend",`+label+`} 10`,
+	)
+	if strings.Contains(body, "include-proxy") {
+		t.Errorf("filter parameters in the url label:\n%s", body)
+	}
+}
+
+func TestMetricsHandlerNoCache(t *testing.T) {
+	var scrapes int32
+	haproxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&scrapes, 1)
+		if _, err := w.Write(testDataCSV); err != nil {
+			panic(err)
+		}
+	}))
+	defer haproxy.Close()
+	u, err := url.Parse(haproxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(newMetricsHandler([]*url.URL{u}, Config{}))
+	defer server.Close()
+
+	_ = getMetrics(t, server)
+	_ = getMetrics(t, server)
+	if got, want := atomic.LoadInt32(&scrapes), int32(2); got != want {
+		t.Errorf("bad scrape count: got %d, want %d", got, want)
+	}
+}
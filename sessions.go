@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// With --sessions, the live sessions are read from the admin socket with
+// show sess, and counted by frontend, backend, server, age and state.
+// Sessions that stay open on a backend for much longer than its requests
+// take are a symptom of stuck upstreams. show sess lists every session, so on
+// busy proxies its output may be cut off at the size limit of socket
+// responses, and then only the sessions that were read are counted.
+
+type session struct {
+	Frontend string
+	Backend  string
+	Server   string
+	Age      time.Duration
+	State    string
+}
+
+// sessionAgeBuckets are the upper bounds of the age buckets that sessions are
+// counted in, and the labels of the buckets.
+var sessionAgeBuckets = []struct {
+	max   time.Duration
+	label string
+}{
+	{10 * time.Second, "10s"},
+	{time.Minute, "1m"},
+	{10 * time.Minute, "10m"},
+	{time.Hour, "1h"},
+	{0, "+Inf"},
+}
+
+// sessionStates are the names of the states of the server side of a session.
+var sessionStates = []string{"INI", "REQ", "QUE", "TAR", "ASS", "CON", "CER", "RDY", "EST", "DIS", "CLO"}
+
+// readSessions reads the live sessions from the admin socket at url.
+func readSessions(url *url.URL) ([]session, error) {
+	data, err := runCommand(url, "show sess")
+	if err != nil {
+		return nil, err
+	}
+	return parseSessions(data), nil
+}
+
+// parseSessions parses the output of show sess, with a line per session
+// like "0x55f5e6c4b4a0: proto=tcpv4 src=127.0.0.1:55078 fe=http be=app
+// srv=app1 ts=00 age=1m3s ... scb=[8,111h,fd=13]". The state of the server
+// side is reported as scb, or s1 by older versions.
+func parseSessions(data []byte) []session {
+	var sessions []session
+	for _, line := range strings.Split(string(data), "\n") {
+		tokens := strings.Fields(line)
+		if len(tokens) < 2 || !strings.HasPrefix(tokens[0], "0x") {
+			continue
+		}
+		fields := keyValueFields(tokens[1:])
+		age := parseHAProxyDuration(fields["age"])
+		if _, ok := fields["fe"]; !ok || age < 0 {
+			continue
+		}
+		s := session{
+			Frontend: fields["fe"],
+			Backend:  fields["be"],
+			Server:   fields["srv"],
+			Age:      age,
+		}
+		for _, key := range []string{"scb", "s1"} {
+			if value, ok := fields[key]; ok {
+				s.State = sessionState(value)
+				break
+			}
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// sessionState returns the name of the state in a "[8,111h,fd=13]" value.
+func sessionState(value string) string {
+	state := strings.SplitN(strings.Trim(value, "[]"), ",", 2)[0]
+	if i, err := strconv.Atoi(state); err == nil && i >= 0 && i < len(sessionStates) {
+		return sessionStates[i]
+	}
+	return state
+}
+
+// ageBucket returns the label of the age bucket of age.
+func ageBucket(age time.Duration) string {
+	for _, bucket := range sessionAgeBuckets {
+		if bucket.max == 0 || age < bucket.max {
+			return bucket.label
+		}
+	}
+	return ""
+}
+
+// match reports whether the backend and server of s pass f. Sessions that
+// are not assigned to a backend yet are matched by their frontend.
+func (s session) match(f *filter) bool {
+	if s.Backend == "<NONE>" {
+		return f.matchName(s.Frontend, "FRONTEND", "frontend")
+	}
+	if !f.matchName(s.Backend, "BACKEND", "backend") {
+		return false
+	}
+	return s.Server == "<NONE>" || f.matchName(s.Backend, s.Server, "server")
+}
+
+// old returns whether s is older than config.SessionAge.
+func (s session) old() bool {
+	return s.Age > time.Duration(config.SessionAge)*time.Second
+}
+
+func exportSessionMetrics(e *exporter, s *socketData) {
+	if s.sessions == nil {
+		return
+	}
+	count := e.gauge("haproxy_sessions", "live sessions", "frontend", "backend", "server", "age", "state")
+	old := e.gauge("haproxy_sessions_long_lived", "live sessions older than --session-age", "backend")
+	counts := make(map[[5]string]float64)
+	olds := make(map[string]float64)
+	for _, session := range s.sessions {
+		counts[[5]string{session.Frontend, session.Backend, session.Server, ageBucket(session.Age), session.State}]++
+		var old float64
+		if session.old() {
+			old = 1
+		}
+		olds[session.Backend] += old
+	}
+	for labels, n := range counts {
+		count.WithLabelValues(labels[:]...).Set(n)
+	}
+	for backend, n := range olds {
+		old.WithLabelValues(backend).Set(n)
+	}
+}
+
+// sessionRule judges every backend by the number of its sessions that are
+// older than config.SessionAge.
+func sessionRule(e *evaluation) []result {
+	if e.socket == nil || (config.OldSessionsWarning <= 0 && config.OldSessionsCritical <= 0) {
+		return nil
+	}
+	type backend struct {
+		old    int
+		oldest session
+	}
+	backends := make(map[string]*backend)
+	var names []string
+	for _, s := range e.socket.sessions {
+		if !s.old() {
+			continue
+		}
+		b, ok := backends[s.Backend]
+		if !ok {
+			b = &backend{}
+			backends[s.Backend] = b
+			names = append(names, s.Backend)
+		}
+		b.old++
+		if s.Age > b.oldest.Age {
+			b.oldest = s
+		}
+	}
+	var results []result
+	for _, name := range names {
+		b := backends[name]
+		status := sensu.CheckStateOK
+		switch {
+		case config.OldSessionsCritical > 0 && b.old >= config.OldSessionsCritical:
+			status = sensu.CheckStateCritical
+		case config.OldSessionsWarning > 0 && b.old >= config.OldSessionsWarning:
+			status = sensu.CheckStateWarning
+		default:
+			continue
+		}
+		results = append(results, result{
+			Proxy:  name,
+			Server: "BACKEND",
+			Type:   "backend",
+			Status: status,
+			Output: fmt.Sprintf("backend %s has %d sessions older than %s, the oldest %s on server %s", name, b.old, time.Duration(config.SessionAge)*time.Second, b.oldest.Age, b.oldest.Server),
+		})
+	}
+	return results
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+const sessionsOutput = `0x55f5e6c4b4a0: proto=tcpv4 src=192.0.2.1:55078 fe=http be=app srv=app1 ts=00 epoch=0 age=3s calls=2 rate=0 cpu=0 lat=0 rq[f=848000h,i=0,an=00h,rx=,wx=,ax=] rp[f=80008000h,i=0,an=00h,rx=,wx=,ax=] scf=[8,200h,fd=12] scb=[8,111h,fd=13] exp=
+0x55f5e6c4b5b0: proto=tcpv4 src=192.0.2.2:55079 fe=http be=app srv=app1 ts=00 epoch=0 age=2h3m calls=2 rate=0 cpu=0 lat=0 rq[f=848000h,i=0,an=00h,rx=,wx=,ax=] rp[f=80008000h,i=0,an=00h,rx=,wx=,ax=] scf=[8,200h,fd=14] scb=[8,111h,fd=15] exp=
+0x55f5e6c4b6c0: proto=tcpv4 src=192.0.2.3:55080 fe=http be=app srv=app2 ts=00 epoch=0 age=1d1h calls=2 rate=0 cpu=0 lat=0 rq[f=848000h,i=0,an=00h,rx=,wx=,ax=] rp[f=80008000h,i=0,an=00h,rx=,wx=,ax=] scf=[8,200h,fd=16] scb=[8,111h,fd=17] exp=
+0x55f5e6c4b7d0: proto=tcpv4 src=192.0.2.4:55081 fe=http be=static srv=<NONE> ts=00 age=5m calls=1 rq[f=848000h,i=0,an=00h,rx=,wx=,ax=] rp[f=80008000h,i=0,an=00h,rx=,wx=,ax=] s0=[7,8h,fd=18,ex=] s1=[2,10h,fd=-1,ex=] exp=
+0x55f5e6c4b8e0: proto=unix_stream src=unix:1 fe=GLOBAL be=<NONE> srv=<NONE> ts=00 age=0s calls=1 rq[f=c08200h,i=0,an=00h,rx=,wx=,ax=] rp[f=80008002h,i=0,an=00h,rx=,wx=,ax=] scf=[8,200h,fd=19] scb=[8,1h,fd=-1] exp=
+`
+
+func TestParseSessions(t *testing.T) {
+	sessions := parseSessions([]byte(sessionsOutput))
+	if len(sessions) != 5 {
+		t.Fatalf("expected five sessions, got %v", sessions)
+	}
+	if s := sessions[1]; s.Frontend != "http" || s.Backend != "app" || s.Server != "app1" || s.Age != 2*time.Hour+3*time.Minute || s.State != "EST" {
+		t.Errorf("bad session: %+v", s)
+	}
+	if s := sessions[3]; s.State != "QUE" || s.Age != 5*time.Minute {
+		t.Errorf("bad session of an older version: %+v", s)
+	}
+	for _, test := range []struct {
+		age  time.Duration
+		want string
+	}{
+		{3 * time.Second, "10s"},
+		{5 * time.Minute, "10m"},
+		{25 * time.Hour, "+Inf"},
+	} {
+		if got := ageBucket(test.age); got != test.want {
+			t.Errorf("bad bucket for %s: got %s, want %s", test.age, got, test.want)
+		}
+	}
+}
+
+func TestSessionRule(t *testing.T) {
+	socket := &socketData{sessions: parseSessions([]byte(sessionsOutput))}
+	cfg := defaultConfig()
+	cfg.SessionAge = 3600
+	withConfig(cfg, func() {
+		if results := sessionRule(&evaluation{socket: socket}); len(results) != 0 {
+			t.Errorf("expected no results when disabled, got %v", results)
+		}
+	})
+	cfg.OldSessionsWarning = 1
+	cfg.OldSessionsCritical = 2
+	withConfig(cfg, func() {
+		results := sessionRule(&evaluation{socket: socket})
+		if len(results) != 1 {
+			t.Fatalf("expected one result, got %v", results)
+		}
+		if got, want := results[0].Status, sensu.CheckStateCritical; got != want {
+			t.Errorf("bad status: got %d, want %d", got, want)
+		}
+		want := "backend app has 2 sessions older than 1h0m0s, the oldest 25h0m0s on server app2"
+		if got := results[0].Output; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+	})
+}
+
+func TestSessionMetrics(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.SessionAge = 3600
+	withConfig(cfg, func() {
+		e := newExporter()
+		exportSessionMetrics(e, &socketData{sessions: parseSessions([]byte(sessionsOutput))})
+		wantMetrics(t, exporterText(t, e),
+			`haproxy_sessions{age="10s",backend="app",frontend="http",server="app1",state="EST"} 1`,
+			`haproxy_sessions{age="+Inf",backend="app",frontend="http",server="app1",state="EST"} 1`,
+			`haproxy_sessions{age="10m",backend="static",frontend="http",server="<NONE>",state="QUE"} 1`,
+			`haproxy_sessions_long_lived{backend="app"} 2`,
+			`haproxy_sessions_long_lived{backend="static"} 0`,
+		)
+	})
+}
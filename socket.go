@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// Besides the stats, some metrics and rules need data that is only available
+// from the admin socket. It is read once per run of a socket URL into a
+// socketData.
+
+type socketData struct {
+	// info is the output of show info.
+	info map[string]string
+	// certs are the certificates loaded in HAProxy, if config.SSLCerts or
+	// config.OCSP is set.
+	certs []certificate
+	// ocsp are the OCSP responses loaded in HAProxy, if config.OCSP is set.
+	ocsp []ocspResponse
+	// tables are the stick tables, if config.StickTables is set.
+	tables []stickTable
+	// resolvers are the nameservers of the resolvers sections, if
+	// config.Resolvers is set.
+	resolvers []nameserver
+	// peers are the peers sections, if config.Peers is set.
+	peers []peersSection
+	// errors are the captured errors, if config.ShowErrors is set.
+	errors *capturedErrors
+	// sessions are the live sessions, if config.Sessions is set.
+	sessions []session
+	// pools are the memory pools, if config.Pools is set.
+	pools []memoryPool
+	// activity are the activity counters, if config.Activity is set.
+	activity map[string]float64
+}
+
+// readSocketData reads everything that config asks for from the admin socket
+// at url. For URLs that are not an admin socket, it returns nil.
+func readSocketData(url *url.URL, config Config) (*socketData, error) {
+	info, err := readInfo(url)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, nil
+	}
+	s := &socketData{info: info}
+	if config.SSLCerts || config.OCSP {
+		if s.certs, err = readCerts(url); err != nil {
+			return nil, err
+		}
+	}
+	if config.OCSP {
+		if s.ocsp, err = readOCSPResponses(url); err != nil {
+			return nil, err
+		}
+	}
+	if config.StickTables {
+		if s.tables, err = readTables(url, config); err != nil {
+			return nil, err
+		}
+	}
+	if config.Resolvers {
+		if s.resolvers, err = readResolvers(url); err != nil {
+			return nil, err
+		}
+	}
+	if config.Peers {
+		if s.peers, err = readPeers(url); err != nil {
+			return nil, err
+		}
+	}
+	if config.ShowErrors {
+		if s.errors, err = readErrors(url); err != nil {
+			return nil, err
+		}
+	}
+	if config.Sessions {
+		if s.sessions, err = readSessions(url); err != nil {
+			return nil, err
+		}
+	}
+	if config.Pools {
+		if s.pools, err = readPools(url); err != nil {
+			return nil, err
+		}
+	}
+	if config.Activity {
+		if s.activity, err = readActivity(url); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// applyFilter drops the sessions and captured errors of the proxies and
+// servers that do not pass f, so that, like the stats rows, they are neither
+// exported nor evaluated.
+func (s *socketData) applyFilter(f *filter) {
+	if s == nil {
+		return
+	}
+	if s.sessions != nil {
+		sessions := make([]session, 0, len(s.sessions))
+		for _, session := range s.sessions {
+			if session.match(f) {
+				sessions = append(sessions, session)
+			}
+		}
+		s.sessions = sessions
+	}
+	if s.errors != nil {
+		var errs []capturedError
+		for _, err := range s.errors.Errors {
+			if err.match(f) {
+				errs = append(errs, err)
+			}
+		}
+		s.errors.Errors = errs
+	}
+}
+
+// counters returns the counters of s that are kept in the state file, so
+// that rules can compare them with the next run.
+func (s *socketData) counters() map[string]map[string]float64 {
+	counters := make(map[string]map[string]float64)
+	for _, ns := range s.resolvers {
+		counters[ns.stateKey()] = ns.Counters
+	}
+	if s.errors != nil {
+		counters["errors"] = map[string]float64{"last_event": s.errors.lastEvent()}
+	}
+	for _, p := range s.pools {
+		counters[p.stateKey()] = map[string]float64{"failures": p.Failures}
+	}
+	return counters
+}
+
+// Socket metrics are exported from the socket data, after the stats metrics.
+var socketMetrics = []func(e *exporter, s *socketData){
+	exportInfoMetrics,
+	exportCertMetrics,
+	exportOCSPMetrics,
+	exportTableMetrics,
+	exportResolverMetrics,
+	exportPeerMetrics,
+	exportErrorMetrics,
+	exportSessionMetrics,
+	exportPoolMetrics,
+	exportActivityMetrics,
+}
+
+// infoMetrics are the show info fields that are exported, by metric name.
+var infoMetrics = map[string]struct{ field, help string }{
+	"ssl_rate":              {"SslRate", "SSL sessions per second"},
+	"ssl_frontend_key_rate": {"SslFrontendKeyRate", "SSL frontend keys computed per second"},
+	"ssl_cache_misses":      {"SslCacheMisses", "SSL session cache misses"},
+}
+
+func exportInfoMetrics(e *exporter, s *socketData) {
+	names := make([]string, 0, len(infoMetrics))
+	for name := range infoMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := infoMetrics[name]
+		value, err := strconv.ParseFloat(s.info[m.field], 64)
+		if err != nil {
+			continue
+		}
+		e.gauge("haproxy_"+name, m.help).WithLabelValues().Set(value)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// Frontends that terminate SSL report the number of SSL sessions in ssl_sess,
+// how many of those resumed an earlier session in ssl_reused_sess, and the
+// number of failed handshakes in ssl_failed_handshake. A spike in failed
+// handshakes usually means clients that cannot agree on a protocol version or
+// cipher suite.
+
+var sslHandshakeNames = []string{"failures", "failure_ratio"}
+
+// sslRatios returns the fraction of the SSL sessions of row that were reused,
+// and the fraction of handshakes that failed.
+func sslRatios(row statRow) (reuse, failure float64, ok bool) {
+	sessions, ok := row.Float("ssl_sess")
+	if !ok {
+		return 0, 0, false
+	}
+	reused, _ := row.Float("ssl_reused_sess")
+	failed, _ := row.Float("ssl_failed_handshake")
+	if sessions > 0 {
+		reuse = reused / sessions
+	}
+	if sessions+failed > 0 {
+		failure = failed / (sessions + failed)
+	}
+	return reuse, failure, sessions+failed > 0
+}
+
+// exportSSLMetrics exports the SSL session reuse and handshake failure ratios
+// of every frontend that handled SSL.
+func exportSSLMetrics(e *exporter, rows []statRow, previous *snapshot) {
+	reuse := e.gauge("haproxy_ssl_reuse_ratio", "SSL session reuse ratio", tags...)
+	failure := e.gauge("haproxy_ssl_failed_handshake_ratio", "SSL handshake failure ratio", tags...)
+	for _, row := range rows {
+		if row.Type() != "frontend" {
+			continue
+		}
+		if r, f, ok := sslRatios(row); ok {
+			reuse.WithLabelValues(rowLabels(row)...).Set(r)
+			failure.WithLabelValues(rowLabels(row)...).Set(f)
+		}
+	}
+}
+
+// sslHandshakeRule judges the handshakes that failed on every frontend since
+// the previous run against the --ssl-handshake-warning and
+// --ssl-handshake-critical thresholds, by their number (failures) or their
+// share of all handshakes (failure_ratio).
+func sslHandshakeRule(e *evaluation) []result {
+	warning, _ := parseThresholds(config.SSLHandshakeWarning, sslHandshakeNames...)
+	critical, _ := parseThresholds(config.SSLHandshakeCritical, sslHandshakeNames...)
+	if len(warning) == 0 && len(critical) == 0 {
+		return nil
+	}
+	var results []result
+	for _, row := range e.rows {
+		if row.Type() != "frontend" {
+			continue
+		}
+		failed, ok := e.delta(row, "ssl_failed_handshake")
+		if !ok || failed == 0 {
+			continue
+		}
+		sessions, _ := e.delta(row, "ssl_sess")
+		values := map[string]float64{
+			"failures":      failed,
+			"failure_ratio": failed / (sessions + failed),
+		}
+		status, limit := sslHandshakeStatus(row, values, warning, critical)
+		if status == sensu.CheckStateOK {
+			continue
+		}
+		results = append(results, newResult(row, status, "frontend %s had %.0f failed SSL handshakes since the previous run (%.1f%% of handshakes), above %s", row.Proxy(), failed, 100*values["failure_ratio"], limit))
+	}
+	return results
+}
+
+// sslHandshakeStatus returns the worst status of the thresholds that values
+// exceed, and a description of the exceeded threshold.
+func sslHandshakeStatus(row statRow, values map[string]float64, warning, critical []threshold) (int, string) {
+	for _, level := range []struct {
+		status     int
+		thresholds []threshold
+	}{
+		{sensu.CheckStateCritical, critical},
+		{sensu.CheckStateWarning, warning},
+	} {
+		for _, name := range sslHandshakeNames {
+			t, ok := findThreshold(level.thresholds, row, name)
+			if !ok || values[name] <= t.value {
+				continue
+			}
+			if name == "failure_ratio" {
+				return level.status, fmt.Sprintf("%.1f%%", 100*t.value)
+			}
+			return level.status, fmt.Sprintf("%.0f failures", t.value)
+		}
+	}
+	return sensu.CheckStateOK, ""
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func sslCSV(t *testing.T) []byte {
+	return setColumns(t, testDataCSV, "main", "FRONTEND", map[string]string{
+		"ssl_sess":             "900",
+		"ssl_reused_sess":      "450",
+		"ssl_failed_handshake": "100",
+	})
+}
+
+func TestSSLHandshakeRule(t *testing.T) {
+	// Only the main frontend, as previousRun changes every FRONTEND row.
+	var rows []statRow
+	for _, row := range testRows(t, sslCSV(t)) {
+		if row.Proxy() != "stats" {
+			rows = append(rows, row)
+		}
+	}
+	tests := []struct {
+		name     string
+		warning  []string
+		critical []string
+		want     int
+		output   string
+	}{
+		{"disabled", nil, nil, sensu.CheckStateOK, ""},
+		{"below", []string{"failures=50"}, nil, sensu.CheckStateOK, ""},
+		{"warning", []string{"failures=10"}, []string{"failures=50"}, sensu.CheckStateWarning, "frontend main had 20 failed SSL handshakes since the previous run (16.7% of handshakes), above 10 failures"},
+		{"critical ratio", []string{"failures=10"}, []string{"main:failure_ratio=0.1"}, sensu.CheckStateCritical, "frontend main had 20 failed SSL handshakes since the previous run (16.7% of handshakes), above 10.0%"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.SSLHandshakeWarning = test.warning
+			cfg.SSLHandshakeCritical = test.critical
+			withConfig(cfg, func() {
+				e := previousRun(rows, "FRONTEND", map[string]float64{"ssl_sess": 100, "ssl_failed_handshake": 20})
+				results := sslHandshakeRule(e)
+				if test.want == sensu.CheckStateOK {
+					if len(results) != 0 {
+						t.Errorf("expected no results, got %v", results)
+					}
+					return
+				}
+				if len(results) != 1 {
+					t.Fatalf("expected one result, got %v", results)
+				}
+				if got := results[0].Status; got != test.want {
+					t.Errorf("bad status: got %d, want %d", got, test.want)
+				}
+				if got := results[0].Output; got != test.output {
+					t.Errorf("bad output:\ngot  %q\nwant %q", got, test.output)
+				}
+			})
+		})
+	}
+}
+
+func TestSSLHandshakeRuleWithoutState(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.SSLHandshakeWarning = []string{"failures=0"}
+	withConfig(cfg, func() {
+		if results := sslHandshakeRule(&evaluation{rows: testRows(t, sslCSV(t))}); len(results) != 0 {
+			t.Errorf("expected no results without a previous run, got %v", results)
+		}
+	})
+}
+
+func TestSSLMetrics(t *testing.T) {
+	socket := &socketData{info: map[string]string{
+		"SslRate":            "12",
+		"SslFrontendKeyRate": "3",
+		"SslCacheMisses":     "42",
+	}}
+	wantMetrics(t, metricsText(t, sslCSV(t), socket),
+		`haproxy_ssl_reuse_ratio{host="",proxy="main",sv="FRONTEND",type="frontend"} 0.5`,
+		`haproxy_ssl_failed_handshake_ratio{host="",proxy="main",sv="FRONTEND",type="frontend"} 0.1`,
+		`haproxy_ssl_failed_handshake{host="",proxy="main",sv="FRONTEND",type="frontend"} 100`,
+		"haproxy_ssl_rate 12",
+		"haproxy_ssl_frontend_key_rate 3",
+		"haproxy_ssl_cache_misses 42",
+	)
+}
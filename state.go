@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Some rules compare the counters of the current run with those of the
+// previous run. To do that, the numeric columns of every row are stored in a
+// JSON state file after each run, keyed by URL and by proxy and server name.
+// Without a state file, those rules have nothing to compare with and are
+// skipped.
+
+type stateFile struct {
+	Targets map[string]*snapshot `json:"targets"`
+}
+
+// snapshot is the state of a single URL.
+type snapshot struct {
+	Time time.Time                     `json:"time"`
+	Rows map[string]map[string]float64 `json:"rows"`
+	// Socket holds the counters read from the admin socket, keyed by what
+	// they were read from.
+	Socket map[string]map[string]float64 `json:"socket,omitempty"`
+}
+
+// loadState reads the state file at path. A missing file is an empty state.
+func loadState(path string) (*stateFile, error) {
+	state := &stateFile{Targets: make(map[string]*snapshot)}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	if state.Targets == nil {
+		state.Targets = make(map[string]*snapshot)
+	}
+	return state, nil
+}
+
+// save writes the state to path. The state is written to a temporary file
+// first, so that a concurrent run never reads a partial state.
+func (s *stateFile) save(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func rowKey(row statRow) string {
+	return row.Proxy() + "/" + row.Server()
+}
+
+// newSnapshot returns the numeric columns of rows.
+func newSnapshot(rows []statRow, now time.Time) *snapshot {
+	s := &snapshot{
+		Time: now,
+		Rows: make(map[string]map[string]float64, len(rows)),
+	}
+	for _, row := range rows {
+		values := make(map[string]float64)
+		for column := range row {
+			if value, ok := row.Float(column); ok {
+				values[column] = value
+			}
+		}
+		s.Rows[rowKey(row)] = values
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	state, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Targets) != 0 {
+		t.Fatalf("expected empty state, got %v", state.Targets)
+	}
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	state.Targets["unix:///run/haproxy/admin.sock"] = newSnapshot(testRows(t, testDataCSV), now)
+	state.Targets["unix:///run/haproxy/admin.sock"].Socket = map[string]map[string]float64{
+		"resolvers/mydns/dns1": {"sent": 8},
+	}
+	if err := state.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := state.Targets["unix:///run/haproxy/admin.sock"]
+	if !ok {
+		t.Fatal("snapshot missing from state")
+	}
+	if !s.Time.Equal(now) {
+		t.Errorf("bad time: got %v, want %v", s.Time, now)
+	}
+	if got, want := s.Rows["stats/FRONTEND"]["smax"], float64(10); got != want {
+		t.Errorf("bad smax: got %v, want %v", got, want)
+	}
+	if _, ok := s.Rows["app/app1"]["status"]; ok {
+		t.Error("non-numeric column in snapshot")
+	}
+	if got, want := s.Socket["resolvers/mydns/dns1"]["sent"], float64(8); got != want {
+		t.Errorf("bad socket counter: got %v, want %v", got, want)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected only the state file in %s, got %d files", dir, len(files))
+	}
+}
+
+func TestLoadStateInvalid(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("{"); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if _, err := loadState(f.Name()); err == nil {
+		t.Error("expected non-nil error")
+	}
+}
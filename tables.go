@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// With --stick-tables, the stick tables are read from the admin socket with
+// show table, which lists the size and use of every table. With --table-top,
+// the entries with the highest --table-top-counter are read as well, with
+// show table <name>, so that the heaviest clients are visible.
+
+// tableCounterRE matches the names of stored counters, which are sent to the
+// admin socket as part of a command.
+var tableCounterRE = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+type stickTable struct {
+	Name string
+	Type string
+	Size float64
+	Used float64
+	// Top are the entries with the highest config.TableTopCounter, highest
+	// first.
+	Top []tableEntry
+}
+
+type tableEntry struct {
+	Key      string
+	Counters map[string]float64
+}
+
+// fill returns the percentage of the table that is used.
+func (t stickTable) fill() float64 {
+	if t.Size == 0 {
+		return 0
+	}
+	return 100 * t.Used / t.Size
+}
+
+// readTables reads the stick tables from the admin socket at url.
+func readTables(url *url.URL, config Config) ([]stickTable, error) {
+	data, err := runCommand(url, "show table")
+	if err != nil {
+		return nil, err
+	}
+	tables := parseTableList(data)
+	if config.TableTop <= 0 {
+		return tables, nil
+	}
+	// The entries of a table can be far larger than the size limit of
+	// runCommand, so they are streamed, keeping only the top entries.
+	for i := range tables {
+		var top []tableEntry
+		command := fmt.Sprintf("show table %s data.%s gt 0", tables[i].Name, config.TableTopCounter)
+		err := scanCommand(url, command, func(line string) {
+			if entry, ok := parseTableEntry(line); ok {
+				top = addTopEntry(top, entry, config.TableTopCounter, config.TableTop)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		tables[i].Top = top
+	}
+	return tables, nil
+}
+
+// parseTableList parses the table headers of show table, which look like
+// "# table: name, type: ip, size:204800, used:171454".
+func parseTableList(data []byte) []stickTable {
+	var tables []stickTable
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "# table:") {
+			continue
+		}
+		var t stickTable
+		for _, field := range strings.Split(strings.TrimPrefix(line, "# "), ",") {
+			parts := strings.SplitN(field, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			value := strings.TrimSpace(parts[1])
+			switch strings.TrimSpace(parts[0]) {
+			case "table":
+				t.Name = value
+			case "type":
+				t.Type = value
+			case "size":
+				t.Size, _ = strconv.ParseFloat(value, 64)
+			case "used":
+				t.Used, _ = strconv.ParseFloat(value, 64)
+			}
+		}
+		tables = append(tables, t)
+	}
+	return tables
+}
+
+// parseTableEntries parses the entries of show table <name>.
+func parseTableEntries(data []byte) []tableEntry {
+	var entries []tableEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		if entry, ok := parseTableEntry(line); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// parseTableEntry parses an entry of show table <name>, which looks like
+// "0x55d8f7e16ca0: key=127.0.0.1 use=0 exp=49753 http_req_rate(10000)=5".
+// Counters are named without their period.
+func parseTableEntry(line string) (tableEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "0x") {
+		return tableEntry{}, false
+	}
+	entry := tableEntry{Counters: make(map[string]float64)}
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := parts[0]
+		if i := strings.Index(name, "("); i >= 0 {
+			name = name[:i]
+		}
+		if name == "key" {
+			entry.Key = parts[1]
+			continue
+		}
+		if value, err := strconv.ParseFloat(parts[1], 64); err == nil {
+			entry.Counters[name] = value
+		}
+	}
+	return entry, true
+}
+
+// topEntries returns the n entries with the highest counter, highest first.
+func topEntries(entries []tableEntry, counter string, n int) []tableEntry {
+	var top []tableEntry
+	for _, entry := range entries {
+		top = addTopEntry(top, entry, counter, n)
+	}
+	return top
+}
+
+// addTopEntry adds entry to top, the at most n entries with the highest
+// counter, highest first. Entries without the counter are skipped, and of
+// entries with the same counter the earliest ones are kept.
+func addTopEntry(top []tableEntry, entry tableEntry, counter string, n int) []tableEntry {
+	value, ok := entry.Counters[counter]
+	if !ok {
+		return top
+	}
+	i := sort.Search(len(top), func(i int) bool {
+		return top[i].Counters[counter] < value
+	})
+	if i >= n {
+		return top
+	}
+	if len(top) < n {
+		top = append(top, tableEntry{})
+	}
+	copy(top[i+1:], top[i:])
+	top[i] = entry
+	return top
+}
+
+func exportTableMetrics(e *exporter, s *socketData) {
+	size := e.gauge("haproxy_stick_table_size", "stick table size", "table", "type")
+	used := e.gauge("haproxy_stick_table_used", "stick table entries used", "table", "type")
+	fill := e.gauge("haproxy_stick_table_fill_percent", "stick table percentage used", "table", "type")
+	top := e.gauge("haproxy_stick_table_top_entry", "counter of the stick table entries with the highest counter", "table", "key", "counter")
+	for _, t := range s.tables {
+		size.WithLabelValues(t.Name, t.Type).Set(t.Size)
+		used.WithLabelValues(t.Name, t.Type).Set(t.Used)
+		fill.WithLabelValues(t.Name, t.Type).Set(t.fill())
+		for _, entry := range t.Top {
+			top.WithLabelValues(t.Name, entry.Key, config.TableTopCounter).Set(entry.Counters[config.TableTopCounter])
+		}
+	}
+}
+
+// tableRule judges stick tables by how full they are.
+func tableRule(e *evaluation) []result {
+	if e.socket == nil {
+		return nil
+	}
+	var results []result
+	for _, t := range e.socket.tables {
+		status := sensu.CheckStateOK
+		switch fill := t.fill(); {
+		case config.TableCritical > 0 && fill >= config.TableCritical:
+			status = sensu.CheckStateCritical
+		case config.TableWarning > 0 && fill >= config.TableWarning:
+			status = sensu.CheckStateWarning
+		default:
+			continue
+		}
+		output := fmt.Sprintf("stick table %s is %.0f%% full (%.0f of %.0f entries)", t.Name, t.fill(), t.Used, t.Size)
+		if len(t.Top) > 0 {
+			keys := make([]string, 0, len(t.Top))
+			for _, entry := range t.Top {
+				keys = append(keys, fmt.Sprintf("%s (%.0f)", entry.Key, entry.Counters[config.TableTopCounter]))
+			}
+			output += fmt.Sprintf(", top %s: %s", config.TableTopCounter, strings.Join(keys, ", "))
+		}
+		results = append(results, result{
+			Subject: t.Name,
+			Type:    "table",
+			Status:  status,
+			Output:  output,
+		})
+	}
+	return results
+}
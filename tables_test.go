@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+const tableListOutput = `# table: front_pub, type: ip, size:1000, used:900
+# table: back_rdp, type: string, size:204800, used:0
+`
+
+const tableEntriesOutput = `# table: front_pub, type: ip, size:1000, used:900
+0x55d8f7e16ca0: key=10.0.0.1 use=0 exp=49753 conn_cur=2 http_req_rate(10000)=5
+0x55d8f7e16d10: key=10.0.0.2 use=0 exp=49753 conn_cur=1 http_req_rate(10000)=500
+0x55d8f7e16d80: key=10.0.0.3 use=0 exp=49753 conn_cur=0 http_req_rate(10000)=50
+0x55d8f7e16df0: key=10.0.0.4 use=0 exp=49753 conn_cur=9
+`
+
+func TestParseTables(t *testing.T) {
+	tables := parseTableList([]byte(tableListOutput))
+	if len(tables) != 2 {
+		t.Fatalf("expected two tables, got %v", tables)
+	}
+	if got := tables[0]; got.Name != "front_pub" || got.Type != "ip" || got.Size != 1000 || got.Used != 900 {
+		t.Errorf("bad table: %+v", got)
+	}
+	if got, want := tables[0].fill(), float64(90); got != want {
+		t.Errorf("bad fill: got %v, want %v", got, want)
+	}
+	top := topEntries(parseTableEntries([]byte(tableEntriesOutput)), "http_req_rate", 2)
+	if len(top) != 2 || top[0].Key != "10.0.0.2" || top[1].Key != "10.0.0.3" {
+		t.Errorf("bad top entries: %v", top)
+	}
+	if top[0].Counters["conn_cur"] != 1 {
+		t.Errorf("bad counters: %v", top[0].Counters)
+	}
+}
+
+func TestTableRule(t *testing.T) {
+	tables := parseTableList([]byte(tableListOutput))
+	tables[0].Top = topEntries(parseTableEntries([]byte(tableEntriesOutput)), "http_req_rate", 2)
+	tests := []struct {
+		warning, critical float64
+		want              int
+	}{
+		{80, 95, sensu.CheckStateWarning},
+		{80, 90, sensu.CheckStateCritical},
+		{0, 0, sensu.CheckStateOK},
+	}
+	for _, test := range tests {
+		cfg := defaultConfig()
+		cfg.TableWarning = test.warning
+		cfg.TableCritical = test.critical
+		cfg.TableTopCounter = "http_req_rate"
+		withConfig(cfg, func() {
+			results := tableRule(&evaluation{socket: &socketData{tables: tables}})
+			if test.want == sensu.CheckStateOK {
+				if len(results) != 0 {
+					t.Errorf("expected no results, got %v", results)
+				}
+				return
+			}
+			if len(results) != 1 || results[0].Status != test.want {
+				t.Fatalf("expected one result with status %d, got %v", test.want, results)
+			}
+			want := "stick table front_pub is 90% full (900 of 1000 entries), top http_req_rate: 10.0.0.2 (500), 10.0.0.3 (50)"
+			if got := results[0].Output; got != want {
+				t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+func TestTableMetrics(t *testing.T) {
+	tables := parseTableList([]byte(tableListOutput))
+	tables[0].Top = topEntries(parseTableEntries([]byte(tableEntriesOutput)), "conn_cur", 1)
+	cfg := defaultConfig()
+	cfg.TableTopCounter = "conn_cur"
+	withConfig(cfg, func() {
+		e := newExporter()
+		exportTableMetrics(e, &socketData{tables: tables})
+		wantMetrics(t, exporterText(t, e),
+			`haproxy_stick_table_fill_percent{table="front_pub",type="ip"} 90`,
+			`haproxy_stick_table_size{table="back_rdp",type="string"} 204800`,
+			`haproxy_stick_table_used{table="front_pub",type="ip"} 900`,
+			`haproxy_stick_table_top_entry{counter="conn_cur",key="10.0.0.4",table="front_pub"} 9`,
+		)
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Thresholds are configured as [proxy[/server]:]name=value, for example
+// rtime=0.5 for every proxy and server, app:rtime=0.2 for the app backend and
+// its servers, or app/app1:rtime=1 for a single server. The proxy and server
+// are patterns, like the filters. When several thresholds apply to a row, the
+// most specific one is used, and of those the last one configured.
+
+type threshold struct {
+	proxy  *pattern
+	server *pattern
+	name   string
+	value  float64
+}
+
+// parseThresholds parses threshold specs. names are the threshold names that
+// are allowed.
+func parseThresholds(specs []string, names ...string) ([]threshold, error) {
+	thresholds := make([]threshold, 0, len(specs))
+	for _, spec := range specs {
+		var t threshold
+		rest := spec
+		if i := strings.LastIndex(spec, ":"); i >= 0 {
+			rest = spec[i+1:]
+			parts := splitScope(spec[:i])
+			proxy, err := parsePattern(parts[0])
+			if err != nil {
+				return nil, err
+			}
+			t.proxy = &proxy
+			if len(parts) == 2 {
+				server, err := parsePattern(parts[1])
+				if err != nil {
+					return nil, err
+				}
+				t.server = &server
+			}
+		}
+		parts := strings.SplitN(rest, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid threshold %q: expected name=value", spec)
+		}
+		t.name = parts[0]
+		if !contains(names, t.name) {
+			return nil, fmt.Errorf("invalid threshold %q: name must be one of %s", spec, strings.Join(names, ", "))
+		}
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold %q: %s", spec, err)
+		}
+		t.value = value
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, nil
+}
+
+// splitScope splits a threshold scope into its proxy and server patterns,
+// taking care not to split a /regular expression/.
+func splitScope(scope string) []string {
+	start := 0
+	if strings.HasPrefix(scope, "/") {
+		if end := strings.Index(scope[1:], "/"); end >= 0 {
+			start = end + 2
+		}
+	}
+	if i := strings.Index(scope[start:], "/"); i >= 0 {
+		return []string{scope[:start+i], scope[start+i+1:]}
+	}
+	return []string{scope}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// specificity returns how specific t is for the named proxy and server, or -1
+// if t does not apply to them. server is empty for anything but a server, and
+// then server patterns do not apply.
+func (t threshold) specificity(proxy, server string) int {
+	if t.proxy == nil {
+		return 0
+	}
+	if !t.proxy.match(proxy) {
+		return -1
+	}
+	if t.server == nil {
+		return 1
+	}
+	if server == "" || !t.server.match(server) {
+		return -1
+	}
+	return 2
+}
+
+// findThreshold returns the threshold named name that applies to row. Server
+// patterns only apply to server rows.
+func findThreshold(thresholds []threshold, row statRow, name string) (threshold, bool) {
+	var server string
+	if row.Type() == "server" {
+		server = row.Server()
+	}
+	return findScopedThreshold(thresholds, row.Proxy(), server, name)
+}
+
+// findScopedThreshold returns the threshold named name that applies to the
+// named proxy and server. It is used for thresholds on things that are not
+// stats rows, but are scoped the same way.
+func findScopedThreshold(thresholds []threshold, proxy, server, name string) (threshold, bool) {
+	var found threshold
+	best := -1
+	for _, t := range thresholds {
+		if t.name != name {
+			continue
+		}
+		if s := t.specificity(proxy, server); s >= best && s >= 0 {
+			found, best = t, s
+		}
+	}
+	return found, best >= 0
+}
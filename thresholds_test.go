@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestParseThresholds(t *testing.T) {
+	thresholds, err := parseThresholds([]string{"rtime=0.5", "app:rtime=0.2", "app/app1:rtime=1", "/^st/:ttime=2"}, latencyColumns...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(thresholds), 4; got != want {
+		t.Fatalf("bad threshold count: got %d, want %d", got, want)
+	}
+	for _, spec := range []string{"rtime", "foo=1", "rtime=fast", "/(/:rtime=1"} {
+		if _, err := parseThresholds([]string{spec}, latencyColumns...); err == nil {
+			t.Errorf("expected non-nil error for %q", spec)
+		}
+	}
+}
+
+func TestFindThreshold(t *testing.T) {
+	thresholds, err := parseThresholds([]string{"rtime=0.5", "app:rtime=0.2", "app/app1:rtime=1", "app:rtime=0.3"}, latencyColumns...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		row  statRow
+		want float64
+	}{
+		{statRow{"pxname": "static", "svname": "BACKEND", "type": 1}, 0.5},
+		{statRow{"pxname": "app", "svname": "BACKEND", "type": 1}, 0.3},
+		{statRow{"pxname": "app", "svname": "app2", "type": 2}, 0.3},
+		{statRow{"pxname": "app", "svname": "app1", "type": 2}, 1},
+	}
+	for _, test := range tests {
+		th, ok := findThreshold(thresholds, test.row, "rtime")
+		if !ok {
+			t.Errorf("no threshold for %s/%s", test.row.Proxy(), test.row.Server())
+			continue
+		}
+		if th.value != test.want {
+			t.Errorf("bad threshold for %s/%s: got %v, want %v", test.row.Proxy(), test.row.Server(), th.value, test.want)
+		}
+	}
+	if _, ok := findThreshold(thresholds, statRow{"pxname": "app"}, "ttime"); ok {
+		t.Error("found threshold for unconfigured name")
+	}
+}
+
+func TestSplitScope(t *testing.T) {
+	tests := map[string][]string{
+		"app":             {"app"},
+		"app/app1":        {"app", "app1"},
+		"/^ap+$/":         {"/^ap+$/"},
+		"/^ap+$//app[12]": {"/^ap+$/", "app[12]"},
+		"app//^app1$/":    {"app", "/^app1$/"},
+	}
+	for scope, want := range tests {
+		got := splitScope(scope)
+		if len(got) != len(want) || got[0] != want[0] || (len(got) == 2 && got[1] != want[1]) {
+			t.Errorf("bad split of %q: got %q, want %q", scope, got, want)
+		}
+	}
+}
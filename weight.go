@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// HAProxy reports the effective weight of a server in weight, and the weight
+// it was configured with, or last set to with set weight, in uweight. The
+// effective weight is lower than the user weight during slowstart, or when an
+// agent check reduced it.
+
+// weightRule warns about servers that are UP, but run with an effective weight
+// below config.MinWeightPercent of their user weight, and about servers whose
+// user weight differs from the expected weight configured for them. A server
+// that is UP with a user weight of 0 receives no traffic, like a server in
+// DRAIN, so it is warned about unless it is expected to have weight 0.
+func weightRule(e *evaluation) []result {
+	expected, _ := parseThresholds(config.ExpectedWeight, "weight")
+	var results []result
+	for _, row := range e.rows {
+		if row.Type() != "server" || !serverUp(row) || adminState(row) != "" {
+			continue
+		}
+		weight, ok := row.Float("weight")
+		if !ok {
+			continue
+		}
+		uweight, ok := row.Float("uweight")
+		if !ok {
+			uweight = weight
+		}
+		t, hasExpected := findThreshold(expected, row, "weight")
+		switch {
+		case uweight == 0:
+			if !hasExpected || t.value != 0 {
+				results = append(results, newResult(row, sensu.CheckStateWarning, "server %s/%s is UP with weight 0 and receives no traffic", row.Proxy(), row.Server()))
+			}
+		case 100*weight/uweight < config.MinWeightPercent:
+			results = append(results, newResult(row, sensu.CheckStateWarning, "server %s/%s is running with effective weight %.0f of %.0f (%.0f%%)", row.Proxy(), row.Server(), weight, uweight, 100*weight/uweight))
+		}
+		if hasExpected && uweight != t.value {
+			results = append(results, newResult(row, sensu.CheckStateWarning, "server %s/%s has weight %.0f, expected %.0f", row.Proxy(), row.Server(), uweight, t.value))
+		}
+	}
+	return results
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func weightCSV(t *testing.T) []byte {
+	csv := statusCSV("UP", "UP", "UP", "MAINT")
+	csv = setColumns(t, csv, "app", "app1", map[string]string{"weight": "100", "uweight": "100"})
+	csv = setColumns(t, csv, "app", "app2", map[string]string{"weight": "20", "uweight": "100"})
+	csv = setColumns(t, csv, "app", "app3", map[string]string{"weight": "0", "uweight": "50"})
+	return setColumns(t, csv, "app", "app4", map[string]string{"weight": "0", "uweight": "100"})
+}
+
+func TestWeightRule(t *testing.T) {
+	withConfig(defaultConfig(), func() {
+		csv := setColumns(t, weightCSV(t), "static", "static", map[string]string{"status": "UP", "weight": "0", "uweight": "0"})
+		results := weightRule(&evaluation{rows: testRows(t, csv)})
+		want := []string{
+			"server static/static is UP with weight 0 and receives no traffic",
+			"server app/app2 is running with effective weight 20 of 100 (20%)",
+			"server app/app3 is running with effective weight 0 of 50 (0%)",
+		}
+		if len(results) != len(want) {
+			t.Fatalf("bad results: got %v, want %v", results, want)
+		}
+		for i, r := range results {
+			if r.Output != want[i] {
+				t.Errorf("bad output:\ngot  %q\nwant %q", r.Output, want[i])
+			}
+			if r.Status != sensu.CheckStateWarning {
+				t.Errorf("bad status: got %d, want %d", r.Status, sensu.CheckStateWarning)
+			}
+		}
+	})
+}
+
+func TestWeightRuleExpected(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.MinWeightPercent = 0
+	cfg.ExpectedWeight = []string{"weight=100", "app/app4:weight=10"}
+	withConfig(cfg, func() {
+		results := weightRule(&evaluation{rows: testRows(t, weightCSV(t))})
+		if len(results) != 1 {
+			t.Fatalf("expected one result, got %v", results)
+		}
+		if got, want := results[0].Output, "server app/app3 has weight 50, expected 100"; got != want {
+			t.Errorf("bad output:\ngot  %q\nwant %q", got, want)
+		}
+	})
+}